@@ -0,0 +1,391 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+    "golang.org/x/term"
+)
+
+func runConfig(args []string) int {
+    if len(args) > 0 {
+        switch args[0] {
+        case "check":
+            return runConfigCheck(args[1:])
+        case "init":
+            return runConfigInit(args[1:])
+        }
+        fmt.Fprintf(os.Stderr, "Unknown config command %q\n\n", args[0])
+    }
+
+    fmt.Fprintf(os.Stderr, `Usage: peertube-monitor config <command> [flags]
+
+Commands:
+  check   Load and validate a config file, then check it against the server
+  init    Write a starter config file using the server's metadata
+`)
+    return exitError
+}
+
+// checkReport prints the result of each check and remembers failures
+type checkReport struct {
+    failed bool
+}
+
+func (r *checkReport) ok(format string, args ...interface{}) {
+    fmt.Printf("  OK    "+format+"\n", args...)
+}
+
+func (r *checkReport) warn(format string, args ...interface{}) {
+    fmt.Printf("  WARN  "+format+"\n", args...)
+}
+
+func (r *checkReport) fail(format string, args ...interface{}) {
+    r.failed = true
+    fmt.Printf("  FAIL  "+format+"\n", args...)
+}
+
+func (r *checkReport) exitCode() int {
+    if r.failed {
+        fmt.Println("\nConfiguration has problems")
+        return exitError
+    }
+    fmt.Println("\nConfiguration is OK")
+    return exitOK
+}
+
+func runConfigCheck(args []string) int {
+    fs := flag.NewFlagSet("config check", flag.ExitOnError)
+    configPath := fs.String("config", "config.json", "Path to configuration file")
+    fs.Parse(args)
+
+    report := &checkReport{}
+    fmt.Printf("Checking %s\n", *configPath)
+
+    cfg, err := config.Load(*configPath)
+    if err != nil {
+        report.fail("Load: %v", err)
+        return report.exitCode()
+    }
+    report.ok("Loaded (credentials from %s)", cfg.GetCredentialSource())
+
+    if err := cfg.Validate(); err != nil {
+        report.fail("Validate: %v", err)
+    } else {
+        report.ok("Validated")
+    }
+    if err := cfg.PrepareFolders(); err != nil {
+        report.fail("Folders: %v", err)
+    } else {
+        report.ok("Folders exist and are writable")
+    }
+
+    if cfg.PeerTube.URL == "" || cfg.PeerTube.Username == "" || cfg.PeerTube.Password == "" {
+        report.fail("PeerTube URL, username and password must be set in the config or PEERTUBE_* variables")
+        return report.exitCode()
+    }
+
+    ctx := context.Background()
+    client := newClient(cfg)
+
+    if err := client.Authenticate(ctx); err != nil {
+        report.fail("Authenticate as %s on %s: %v", cfg.PeerTube.Username, cfg.PeerTube.URL, err)
+        return report.exitCode()
+    }
+    report.ok("Authenticated as %s on %s", cfg.PeerTube.Username, cfg.PeerTube.URL)
+
+    if channels, err := client.ListChannels(ctx); err != nil {
+        report.fail("List channels: %v", err)
+    } else if channel, err := cfg.ResolveChannel(channels); err != nil {
+        report.fail("Resolve channel: %v", err)
+    } else {
+        report.ok("Channel %d %q (%s)", channel.ID, channel.DisplayName, channel.Name)
+    }
+
+    if quota, err := client.GetQuota(ctx); err != nil {
+        report.warn("Quota: %v", err)
+    } else {
+        report.ok("Quota: %s", quota)
+    }
+
+    metadata, err := client.FetchMetadata(ctx)
+    if err != nil {
+        report.fail("Fetch metadata: %v", err)
+        return report.exitCode()
+    }
+    if err := cfg.ResolveMetadata(metadata.Categories, metadata.Licences, metadata.Privacies, metadata.Languages); err != nil {
+        report.fail("Resolve metadata: %v", err)
+    } else {
+        report.ok("Defaults: category=%q, licence=%q, privacy=%q, language=%q",
+            metadata.Categories[strconv.Itoa(cfg.PeerTube.Defaults.Category)],
+            metadata.Licences[strconv.Itoa(cfg.PeerTube.Defaults.Licence)],
+            metadata.Privacies[strconv.Itoa(cfg.PeerTube.Defaults.Privacy)],
+            metadata.Languages[cfg.PeerTube.Defaults.Language])
+    }
+
+    return report.exitCode()
+}
+
+// prompter asks for values that were not given as flags
+type prompter struct {
+    in          *bufio.Reader
+    interactive bool
+}
+
+// ask returns value if set, otherwise prompts with def as the suggestion.
+// Without a terminal def is used as is.
+func (p *prompter) ask(label, value, def string) string {
+    if value != "" || !p.interactive {
+        if value == "" {
+            return def
+        }
+        return value
+    }
+
+    if def != "" {
+        fmt.Printf("%s [%s]: ", label, def)
+    } else {
+        fmt.Printf("%s: ", label)
+    }
+    line, _ := p.in.ReadString('\n')
+    if line = strings.TrimSpace(line); line != "" {
+        return line
+    }
+    return def
+}
+
+// askPassword is like ask without a suggestion, but does not echo what is
+// typed
+func (p *prompter) askPassword(label, value string) string {
+    if value != "" || !p.interactive {
+        return value
+    }
+
+    fmt.Printf("%s: ", label)
+    password, err := term.ReadPassword(int(os.Stdin.Fd()))
+    fmt.Println()
+    if err != nil {
+        return ""
+    }
+    return strings.TrimSpace(string(password))
+}
+
+// choose asks for one of the options by ID or name and returns its name
+func (p *prompter) choose(label, value, def string, options map[string]string) (string, error) {
+    for {
+        answer := p.ask(label, value, def)
+        name, ok := lookupOption(answer, options)
+        if ok {
+            return name, nil
+        }
+
+        err := fmt.Errorf("%s: unknown value %q", strings.ToLower(label), answer)
+        if answer == "" {
+            err = fmt.Errorf("%s is required", strings.ToLower(label))
+        }
+        if !p.interactive || value != "" {
+            return "", err
+        }
+        fmt.Println(err)
+    }
+}
+
+func lookupOption(answer string, options map[string]string) (string, bool) {
+    if name, ok := options[answer]; ok {
+        return name, true
+    }
+    for _, name := range options {
+        if strings.EqualFold(name, answer) {
+            return name, true
+        }
+    }
+    return "", false
+}
+
+func printOptions(w io.Writer, title string, options map[string]string) {
+    var ids []string
+    for id := range options {
+        ids = append(ids, id)
+    }
+    sort.Slice(ids, func(i, j int) bool {
+        a, _ := strconv.Atoi(ids[i])
+        b, _ := strconv.Atoi(ids[j])
+        return a < b
+    })
+
+    fmt.Fprintf(w, "\n%s:\n", title)
+    for _, id := range ids {
+        fmt.Fprintf(w, "  %3s  %s\n", id, options[id])
+    }
+}
+
+func runConfigInit(args []string) int {
+    fs := flag.NewFlagSet("config init", flag.ExitOnError)
+    output := fs.String("output", "config.json", "File to write (.json, .yaml or .toml)")
+    force := fs.Bool("force", false, "Overwrite an existing file")
+    nonInteractive := fs.Bool("non-interactive", false, "Don't prompt; use flags and defaults only")
+    url := fs.String("url", os.Getenv("PEERTUBE_URL"), "PeerTube instance URL")
+    username := fs.String("username", os.Getenv("PEERTUBE_USERNAME"), "PeerTube username")
+    password := fs.String("password", os.Getenv("PEERTUBE_PASSWORD"), "PeerTube password")
+    savePassword := fs.Bool("save-password", false, "Write the password to the config file")
+    channel := fs.String("channel", "", "Channel to upload to, by ID, handle or display name (default: first channel)")
+    category := fs.String("category", "", "Default category, by name or ID")
+    licence := fs.String("licence", "", "Default licence, by name or ID")
+    privacy := fs.String("privacy", "", "Default privacy, by name or ID")
+    language := fs.String("language", "", "Default language, by code or name")
+    watchPath := fs.String("watch", "", "Folder to watch")
+    donePath := fs.String("done", "", "Folder for uploaded files (empty = delete)")
+    failedPath := fs.String("failed", "", "Folder for failed files")
+    fs.Parse(args)
+
+    if _, err := os.Stat(*output); err == nil && !*force {
+        fmt.Fprintf(os.Stderr, "%s already exists (use -force to overwrite)\n", *output)
+        return exitError
+    }
+
+    stat, _ := os.Stdin.Stat()
+    p := &prompter{
+        in:          bufio.NewReader(os.Stdin),
+        interactive: !*nonInteractive && stat != nil && stat.Mode()&os.ModeCharDevice != 0,
+    }
+
+    // Connect first so the choices can be listed
+    *url = p.ask("PeerTube URL", *url, "")
+    *username = p.ask("Username", *username, "")
+    *password = p.askPassword("Password", *password)
+    if *url == "" || *username == "" || *password == "" {
+        fmt.Fprintln(os.Stderr, "URL, username and password are required")
+        return exitError
+    }
+
+    cfg := &config.Config{PeerTube: config.PeerTubeConfig{URL: *url, Username: *username, Password: *password}}
+    cfg.PeerTube.Timeouts = config.TimeoutsConfig{Connect: 30, Idle: 120}
+    client := newClient(cfg)
+
+    ctx := context.Background()
+    if err := client.Authenticate(ctx); err != nil {
+        fmt.Fprintf(os.Stderr, "Authentication failed: %v\n", err)
+        return exitError
+    }
+    metadata, err := client.FetchMetadata(ctx)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Failed to fetch metadata: %v\n", err)
+        return exitError
+    }
+    channels, err := client.ListChannels(ctx)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Failed to list channels: %v\n", err)
+        return exitError
+    }
+
+    channelOptions := make(map[string]string)
+    for _, c := range channels {
+        channelOptions[strconv.Itoa(c.ID)] = fmt.Sprintf("%s (%s)", c.DisplayName, c.Name)
+    }
+    if p.interactive {
+        printOptions(os.Stdout, "Channels", channelOptions)
+        printOptions(os.Stdout, "Categories", metadata.Categories)
+        printOptions(os.Stdout, "Licences", metadata.Licences)
+        printOptions(os.Stdout, "Privacy levels", metadata.Privacies)
+        fmt.Println()
+    }
+
+    defaults := map[string]interface{}{}
+
+    defChannel := ""
+    if len(channels) > 0 {
+        defChannel = channels[0].Name
+    }
+    for {
+        answer := p.ask("Channel", *channel, defChannel)
+        ref, id := answer, 0
+        if n, err := strconv.Atoi(answer); err == nil {
+            ref, id = "", n
+        }
+        found, err := config.FindChannel(channels, ref, id)
+        if err == nil {
+            defaults["channel"] = found.Name
+            break
+        }
+        if !p.interactive || *channel != "" {
+            fmt.Fprintf(os.Stderr, "%v\n", err)
+            return exitError
+        }
+        fmt.Println(err)
+    }
+
+    for _, field := range []struct {
+        key, label, value, def string
+        options               map[string]string
+    }{
+        {"category", "Category", *category, "", metadata.Categories},
+        {"licence", "Licence", *licence, "", metadata.Licences},
+        {"privacy", "Privacy", *privacy, metadata.Privacies["1"], metadata.Privacies},
+    } {
+        name, err := p.choose(field.label, field.value, field.def, field.options)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "%v\n", err)
+            return exitError
+        }
+        defaults[field.key] = name
+    }
+    for {
+        answer := p.ask("Language (code or name, empty = none)", *language, "")
+        code, err := config.ResolveLanguage(answer, metadata.Languages)
+        if err == nil {
+            if code != "" {
+                defaults["language"] = code
+            }
+            break
+        }
+        if !p.interactive || *language != "" {
+            fmt.Fprintf(os.Stderr, "%v\n", err)
+            return exitError
+        }
+        fmt.Println(err)
+    }
+
+    watcherCfg := map[string]interface{}{
+        "watchPath": p.ask("Watch folder", *watchPath, filepath.Join(".", "upload")),
+    }
+    if done := p.ask("Done folder (empty = delete uploaded files)", *donePath, ""); done != "" {
+        watcherCfg["donePath"] = done
+    }
+    if failed := p.ask("Failed folder (empty = rename with .failed)", *failedPath, ""); failed != "" {
+        watcherCfg["failedPath"] = failed
+    }
+
+    peertubeCfg := map[string]interface{}{
+        "url":      *url,
+        "username": *username,
+        "defaults": defaults,
+    }
+    if *savePassword {
+        peertubeCfg["password"] = *password
+    }
+
+    raw := map[string]interface{}{
+        "peertube": peertubeCfg,
+        "watcher":  watcherCfg,
+    }
+    if err := config.WriteFile(*output, raw); err != nil {
+        fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", *output, err)
+        return exitError
+    }
+
+    fmt.Printf("Wrote %s\n", *output)
+    if !*savePassword {
+        fmt.Println("The password was not saved; set PEERTUBE_PASSWORD when running the monitor.")
+    }
+    fmt.Printf("Check it with: peertube-monitor config check -config %s\n", *output)
+    return exitOK
+}
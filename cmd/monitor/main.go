@@ -1,32 +1,89 @@
 package main
 
 import (
+    "context"
     "flag"
     "fmt"
     "log"
     "os"
+    "os/signal"
     "sort"
+    "strings"
+    "syscall"
+    "time"
 
     "github.com/dsu-teknik/peertube-monitor/pkg/config"
+    "github.com/dsu-teknik/peertube-monitor/pkg/logging"
+    "github.com/dsu-teknik/peertube-monitor/pkg/notify"
     "github.com/dsu-teknik/peertube-monitor/pkg/peertube"
     "github.com/dsu-teknik/peertube-monitor/pkg/watcher"
 )
 
+// serviceName is the Windows service name and the native log source
+const serviceName = "PeerTubeMonitor"
+
+// Process exit codes
+const (
+    exitOK          = 0
+    exitError       = 1
+    exitInterrupted = 2 // shutdown had to cancel uploads in progress
+    exitPaused      = 3 // run -once held files because the server could not be used
+)
+
 var (
     version = "dev"
     commit  = "unknown"
 )
 
 func main() {
-    configPath := flag.String("config", "config.json", "Path to configuration file")
-    logFile := flag.String("log", "", "Path to log file (default: stdout)")
-    verbose := flag.Bool("verbose", false, "Enable verbose logging")
-    showVersion := flag.Bool("version", false, "Show version information")
-    flag.Parse()
+    os.Exit(run(os.Args[1:]))
+}
+
+// run dispatches to a subcommand. Without one the service runs, so service
+// definitions that only pass flags keep working.
+func run(args []string) int {
+    if len(args) > 0 {
+        switch args[0] {
+        case "run":
+            return runDaemon(args[1:])
+        case "config":
+            return runConfig(args[1:])
+        case "upload":
+            return runUpload(args[1:])
+        case "help", "-h", "-help", "--help":
+            usage()
+            return exitOK
+        }
+    }
+    return runDaemon(args)
+}
+
+func usage() {
+    fmt.Fprintf(os.Stderr, `Usage: peertube-monitor [command] [flags]
+
+Commands:
+  run            Watch the folder and upload new videos (default)
+  config check   Validate a config file against the server
+  config init    Write a starter config file
+  upload         Upload files or folders once and exit
+
+Run "peertube-monitor <command> -h" for the flags of a command.
+`)
+}
+
+func runDaemon(args []string) int {
+    fs := flag.NewFlagSet("run", flag.ExitOnError)
+    configPath := fs.String("config", "config.json", "Path to configuration file")
+    logFile := fs.String("log", "", "Path to log file (overrides logging.logFile, default: stdout)")
+    verbose := fs.Bool("verbose", false, "Enable verbose logging")
+    showVersion := fs.Bool("version", false, "Show version information")
+    once := fs.Bool("once", false, "Upload the files already in the watch folder, then exit")
+    dryRun := fs.Bool("dry-run", false, "Log what would be uploaded and where files would go, without uploading or moving them")
+    fs.Parse(args)
 
     if *showVersion {
         fmt.Printf("PeerTube Monitor %s\n", version)
-        os.Exit(0)
+        return exitOK
     }
 
     // Print startup banner to stderr
@@ -41,78 +98,156 @@ func main() {
     if err := cfg.Validate(); err != nil {
         log.Fatalf("Invalid configuration: %v", err)
     }
+    // A dry run touches nothing, so the folders must already exist
+    if !*dryRun {
+        if err := cfg.PrepareFolders(); err != nil {
+            log.Fatalf("Invalid configuration: %v", err)
+        }
+    }
 
-    // Setup logging
-    logger := setupLogger(*logFile, *verbose)
+    // Setup logging, command line flags take precedence over the config file
+    applyFlags := func(cfg *config.Config) {
+        if *logFile != "" {
+            cfg.Logging.LogFile = *logFile
+        }
+        if *verbose {
+            cfg.Logging.Verbose = true
+        }
+    }
+    applyFlags(cfg)
+    logOutput := setupLogger(cfg.Logging)
+    defer logOutput.Close()
+    logger := logOutput.Logger
     logger.Printf("PeerTube Monitor %s (commit %s) starting...", version, commit)
     logger.Printf("Configuration loaded from: %s", *configPath)
     logger.Printf("Credentials loaded from: %s", cfg.GetCredentialSource())
 
+    ctx := context.Background()
+
     // Create PeerTube client
-    client := peertube.NewClient(
-        cfg.PeerTube.URL,
-        cfg.PeerTube.Username,
-        cfg.PeerTube.Password,
-    )
+    client := newClient(cfg)
+    if cfg.PeerTube.UploadRateLimit > 0 {
+        logger.Printf("Upload rate limit: %d bytes/s", cfg.PeerTube.UploadRateLimit)
+    }
 
-    // Validate credentials are configured
-    if cfg.PeerTube.URL == "" || cfg.PeerTube.Username == "" || cfg.PeerTube.Password == "" {
+    // Validate credentials are configured. Until the server can be used,
+    // uploads are paused and the server is retried in the background.
+    var metadata *peertube.MetadataCache
+    pauseReason := ""
+    if !hasCredentials(cfg) {
         logger.Printf("WARNING: PeerTube credentials not configured!")
-        logger.Printf("Please edit config file and restart service")
+        logger.Printf("Please edit the config file; it is reloaded automatically")
         logger.Printf("Config location: %s", *configPath)
+        pauseReason = "PeerTube credentials not configured"
     } else {
         // Test authentication only if credentials are provided
         logger.Printf("Authenticating with PeerTube server: %s", cfg.PeerTube.URL)
-        if err := client.Authenticate(); err != nil {
+        if err := client.Authenticate(ctx); err != nil {
             logger.Printf("WARNING: Authentication failed: %v", err)
-            logger.Printf("Service will start but uploads will fail until credentials are fixed")
+            pauseReason = "PeerTube authentication failed"
         } else {
             logger.Printf("Authentication successful")
 
-            // Fetch metadata from PeerTube
+            if quota, err := client.GetQuota(ctx); err != nil {
+                logger.Printf("WARNING: Failed to fetch quota: %v", err)
+            } else {
+                logger.Printf("Video quota: %s", quota)
+            }
+
+            // Fetch metadata and channels from PeerTube
             logger.Printf("Fetching video metadata from PeerTube server...")
-            metadata, err := client.FetchMetadata()
-            if err != nil {
+            if metadata, err = fetchMetadata(ctx, client, cfg, *dryRun, logger); err != nil {
                 logger.Printf("WARNING: Failed to fetch metadata: %v", err)
-                logger.Printf("Will use default values from config")
+                pauseReason = "PeerTube metadata not available"
+            }
+        }
+
+        // Fall back to the metadata of an earlier run while the server is down
+        if metadata == nil {
+            if m, err := cachedMetadata(cfg); err != nil {
+                logger.Printf("WARNING: No cached metadata: %v", err)
+                logger.Printf("Will use raw values from config")
             } else {
-                logger.Printf("Available categories: %d options", len(metadata.Categories))
-                if *verbose {
-                    logSortedMetadata(logger, metadata.Categories)
-                }
-
-                logger.Printf("Available licences: %d options", len(metadata.Licences))
-                if *verbose {
-                    logSortedMetadata(logger, metadata.Licences)
-                }
-
-                logger.Printf("Available privacy levels: %d options", len(metadata.Privacies))
-                if *verbose {
-                    logSortedMetadata(logger, metadata.Privacies)
-                }
-
-                // Resolve metadata in config
-                if err := cfg.ResolveMetadata(metadata.Categories, metadata.Licences, metadata.Privacies); err != nil {
-                    logger.Printf("WARNING: Invalid configuration: %v", err)
-                    logger.Printf("Will use raw values from config")
-                } else {
-                    logger.Printf("Video defaults: category=%q, licence=%q, privacy=%q",
-                        metadata.Categories[fmt.Sprintf("%d", cfg.PeerTube.Defaults.Category)],
-                        metadata.Licences[fmt.Sprintf("%d", cfg.PeerTube.Defaults.Licence)],
-                        metadata.Privacies[fmt.Sprintf("%d", cfg.PeerTube.Defaults.Privacy)])
-                }
+                logger.Printf("Using metadata cached at %s until the server can be reached", m.Fetched.Format(time.RFC3339))
+                metadata = m
             }
         }
     }
 
+    if metadata != nil {
+        logger.Printf("Available categories: %d options", len(metadata.Categories))
+        if cfg.Logging.Verbose {
+            logSortedMetadata(logger, metadata.Categories)
+        }
+
+        logger.Printf("Available licences: %d options", len(metadata.Licences))
+        if cfg.Logging.Verbose {
+            logSortedMetadata(logger, metadata.Licences)
+        }
+
+        logger.Printf("Available privacy levels: %d options", len(metadata.Privacies))
+        if cfg.Logging.Verbose {
+            logSortedMetadata(logger, metadata.Privacies)
+        }
+
+        logger.Printf("Available languages: %d options", len(metadata.Languages))
+        if cfg.Logging.Verbose {
+            logSortedMetadata(logger, metadata.Languages)
+        }
+
+        // Resolve the upload channel once rather than on every upload
+        if channel, err := cfg.ResolveChannel(metadata.Channels); err != nil {
+            logger.Printf("ERROR: Invalid configuration: %v", err)
+            logger.Printf("Files are held until the channel setting is fixed")
+        } else {
+            logger.Printf("Upload channel: %d (%s)", channel.ID, channel.DisplayName)
+        }
+
+        // Resolve metadata in config
+        if err := cfg.ResolveMetadata(metadata.Categories, metadata.Licences, metadata.Privacies, metadata.Languages); err != nil {
+            logger.Printf("WARNING: Invalid configuration: %v", err)
+            logger.Printf("Will use raw values from config")
+        } else {
+            logger.Printf("Video defaults: category=%q, licence=%q, privacy=%q, language=%q",
+                metadata.Categories[fmt.Sprintf("%d", cfg.PeerTube.Defaults.Category)],
+                metadata.Licences[fmt.Sprintf("%d", cfg.PeerTube.Defaults.Licence)],
+                metadata.Privacies[fmt.Sprintf("%d", cfg.PeerTube.Defaults.Privacy)],
+                metadata.Languages[cfg.PeerTube.Defaults.Language])
+        }
+    }
+
+    // Create notification dispatcher; a dry run sends no notifications
+    var notifier notify.Notifier
+    if *dryRun {
+        logger.Printf("DRY RUN: nothing will be uploaded, moved or deleted, and no hooks or notifications run")
+    } else {
+        dispatcher, err := notify.New(cfg, logger)
+        if err != nil {
+            log.Fatalf("Failed to set up notifications: %v", err)
+        }
+        defer dispatcher.Close()
+        notifier = dispatcher
+        if len(cfg.Notifications.Webhooks) > 0 {
+            logger.Printf("Webhook notifications: %d configured", len(cfg.Notifications.Webhooks))
+        }
+        if cfg.Notifications.Email.Host != "" {
+            logger.Printf("Email notifications: %s via %s", strings.Join(cfg.Notifications.Email.To, ", "), cfg.Notifications.Email.Host)
+        }
+    }
+
     // Create upload handler
-    handler := watcher.NewUploadHandler(client, cfg, logger)
+    handler := watcher.NewUploadHandler(client, cfg, notifier, logger)
+    handler.SetDryRun(*dryRun)
 
     // Create and start watcher
     w, err := watcher.New(
         cfg.Watcher.WatchPath,
         cfg.Watcher.VideoExtensions,
         cfg.Watcher.SettleTime,
+        watcher.Backend{
+            Kind:         cfg.Watcher.Backend,
+            PollInterval: time.Duration(cfg.Watcher.PollInterval) * time.Second,
+        },
         handler,
         logger,
     )
@@ -120,6 +255,8 @@ func main() {
         log.Fatalf("Failed to create watcher: %v", err)
     }
     defer w.Stop()
+    w.SetReadiness(readiness(cfg))
+    w.SetFilter(fileFilter(cfg))
 
     logger.Printf("Monitoring folder: %s", cfg.Watcher.WatchPath)
     if cfg.Watcher.DonePath != "" {
@@ -133,30 +270,164 @@ func main() {
         logger.Printf("Failed action: Rename with .failed extension")
     }
 
+    if len(cfg.Watcher.UploadWindows) > 0 {
+        logger.Printf("Upload windows: %s", strings.Join(cfg.Watcher.UploadWindows, ", "))
+    }
+
+    // The reloader applies config changes and supervises the server
+    reload := &reloader{
+        path:       *configPath,
+        applyFlags: applyFlags,
+        current:    cfg,
+        client:     client,
+        handler:    handler,
+        watcher:    w,
+        logger:     logger,
+        dryRun:     *dryRun,
+        ready:      pauseReason == "",
+    }
+    handler.OnServerDown(reload.serverDown)
+    if pauseReason != "" {
+        reload.mu.Lock()
+        reload.pause(pauseReason)
+        reload.mu.Unlock()
+    }
+
+    // Batch mode for cron and scheduled tasks
+    if *once {
+        return runOnce(w, handler, time.Duration(cfg.Watcher.ShutdownTimeout)*time.Second, logger)
+    }
+
+    // Reload the configuration when the file changes
+    stopWatching, err := watchConfigFile(*configPath, reload.reload, logger)
+    if err != nil {
+        logger.Printf("WARNING: Not watching config file for changes: %v", err)
+    } else {
+        defer stopWatching()
+    }
+
     // Run service (platform-specific implementation)
-    if err := runService(w, logger); err != nil {
-        log.Fatalf("Service error: %v", err)
+    code, err := runService(w, reload, logger)
+    if err != nil {
+        logger.Printf("ERROR: Service error: %v", err)
+        return exitError
     }
+
+    logger.Printf("Shutdown complete")
+    return code
 }
 
-func setupLogger(logFile string, verbose bool) *log.Logger {
-    var output *os.File
+// shutdown stops the watcher, letting active uploads finish within timeout,
+// and returns the exit code to report
+func shutdown(w *watcher.Watcher, timeout time.Duration, logger *log.Logger) int {
+    if active := w.ActiveUploads(); active > 0 {
+        logger.Printf("Waiting up to %s for %d active upload(s) to finish", timeout, active)
+    }
 
-    if logFile != "" {
-        var err error
-        output, err = os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-        if err != nil {
-            log.Fatalf("Failed to open log file: %v", err)
+    interrupted := w.Shutdown(timeout)
+    if len(interrupted) > 0 {
+        for _, path := range interrupted {
+            logger.Printf("WARNING: Interrupted upload: %s", path)
         }
-        // Note: We don't close this file as it's used throughout the program's lifetime
-    } else {
-        output = os.Stdout
+        return exitInterrupted
+    }
+    return exitOK
+}
+
+// newClient creates a PeerTube client with the connection settings of cfg
+func newClient(cfg *config.Config) *peertube.Client {
+    client := peertube.NewClient(
+        cfg.PeerTube.URL,
+        cfg.PeerTube.Username,
+        cfg.PeerTube.Password,
+    )
+
+    client.SetTimeouts(peertube.Timeouts{
+        Connect:  time.Duration(cfg.PeerTube.Timeouts.Connect) * time.Second,
+        Idle:     time.Duration(cfg.PeerTube.Timeouts.Idle) * time.Second,
+        Response: time.Duration(cfg.PeerTube.Timeouts.Response) * time.Second,
+        Total:    time.Duration(cfg.PeerTube.Timeouts.Total) * time.Second,
+    })
+
+    if cfg.PeerTube.UploadRateLimit > 0 {
+        client.SetUploadRateLimit(cfg.PeerTube.UploadRateLimit)
     }
+    return client
+}
 
-    logger := log.New(output, "", log.LstdFlags)
+// readiness returns the checks a settled file must pass before upload
+func readiness(cfg *config.Config) watcher.Readiness {
+    return watcher.Readiness{
+        StableChecks: cfg.Watcher.Readiness.StableChecks,
+        NotInUse:     cfg.Watcher.Readiness.NotInUse,
+        MarkerSuffix: cfg.Watcher.Readiness.MarkerSuffix,
+        MinAge:       time.Duration(cfg.Watcher.Readiness.MinAge) * time.Second,
+    }
+}
+
+// fileFilter returns which files in the watch folder are uploaded
+func fileFilter(cfg *config.Config) watcher.Filter {
+    return watcher.Filter{
+        Include:      cfg.Watcher.Filter.Include,
+        Exclude:      cfg.Watcher.Filter.Exclude,
+        MinSize:      cfg.Watcher.Filter.MinSize,
+        MaxSize:      cfg.Watcher.Filter.MaxSize,
+        SniffContent: cfg.Watcher.Filter.SniffContent,
+    }
+}
+
+// runOnce uploads the files already in the watch folder and logs a summary.
+// It fails if any file failed; held files are left for the next run, but
+// files held because the server could not be used are reported too.
+func runOnce(w *watcher.Watcher, handler *watcher.UploadHandler, shutdownTimeout time.Duration, logger *log.Logger) int {
+    sigChan := make(chan os.Signal, 1)
+    signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+    defer signal.Stop(sigChan)
+
+    go func() {
+        if _, ok := <-sigChan; ok {
+            logger.Printf("Shutdown signal received, stopping...")
+            shutdown(w, shutdownTimeout, logger)
+        }
+    }()
 
-    if verbose {
-        logger.SetFlags(log.LstdFlags | log.Lshortfile)
+    summary, err := w.RunOnce()
+    if err != nil {
+        logger.Printf("ERROR: %v", err)
+        return exitError
+    }
+
+    var counts []string
+    for _, status := range []string{watcher.StatusUploaded, watcher.StatusDryRun, watcher.StatusFailed,
+        watcher.StatusSkipped, watcher.StatusHeld, watcher.StatusInterrupted} {
+        if n := len(summary[status]); n > 0 {
+            counts = append(counts, fmt.Sprintf("%d %s", n, status))
+        }
+    }
+    if len(counts) == 0 {
+        counts = append(counts, "no files")
+    }
+    logger.Printf("Summary: %s", strings.Join(counts, ", "))
+    for _, path := range summary[watcher.StatusFailed] {
+        logger.Printf("  failed: %s", path)
+    }
+
+    switch {
+    case len(summary[watcher.StatusFailed]) > 0:
+        return exitError
+    case len(summary[watcher.StatusInterrupted]) > 0:
+        return exitInterrupted
+    case len(summary[watcher.StatusHeld]) > 0 && handler.PausedHolds() > 0:
+        logger.Printf("ERROR: Files were held because the PeerTube server could not be used")
+        return exitPaused
+    }
+    return exitOK
+}
+
+func setupLogger(cfg config.LoggingConfig) *logging.Logger {
+    logger, err := logging.New(cfg, serviceName)
+    if err != nil {
+        log.Fatalf("Failed to set up logging: %v", err)
     }
 
     return logger
@@ -0,0 +1,49 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+    "github.com/dsu-teknik/peertube-monitor/pkg/peertube"
+)
+
+// hasCredentials reports whether the server and login are configured
+func hasCredentials(cfg *config.Config) bool {
+    return cfg.PeerTube.URL != "" && cfg.PeerTube.Username != "" && cfg.PeerTube.Password != ""
+}
+
+// fetchMetadata fetches the server's metadata and the user's channels, and
+// saves them to the metadata cache for when the server is down. A dry run
+// leaves the cache as it is.
+func fetchMetadata(ctx context.Context, client *peertube.Client, cfg *config.Config, dryRun bool, logger *log.Logger) (*peertube.MetadataCache, error) {
+    metadata, err := client.FetchMetadataCache(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    if cfg.PeerTube.MetadataCache != "" && !dryRun {
+        if err := metadata.Save(cfg.PeerTube.MetadataCache); err != nil {
+            logger.Printf("WARNING: Failed to save metadata cache: %v", err)
+        }
+    }
+    return metadata, nil
+}
+
+// cachedMetadata returns the metadata last fetched from the configured
+// server by fetchMetadata
+func cachedMetadata(cfg *config.Config) (*peertube.MetadataCache, error) {
+    if cfg.PeerTube.MetadataCache == "" {
+        return nil, errors.New("no cache file configured")
+    }
+    return peertube.LoadMetadataCache(cfg.PeerTube.MetadataCache, cfg.PeerTube.URL, cfg.PeerTube.Username)
+}
+
+// resolveDefaults resolves the names in the video defaults and the channel
+func resolveDefaults(cfg *config.Config, metadata *peertube.MetadataCache) (*peertube.Channel, error) {
+    if err := cfg.ResolveMetadata(metadata.Categories, metadata.Licences, metadata.Privacies, metadata.Languages); err != nil {
+        return nil, err
+    }
+    return cfg.ResolveChannel(metadata.Channels)
+}
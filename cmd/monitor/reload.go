@@ -0,0 +1,227 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "log"
+    "path/filepath"
+    "reflect"
+    "sync"
+    "time"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+    "github.com/dsu-teknik/peertube-monitor/pkg/peertube"
+    "github.com/dsu-teknik/peertube-monitor/pkg/watcher"
+    "github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay collects the burst of events editors produce when saving
+const reloadDelay = time.Second
+
+// reloader re-reads the configuration file and applies it to the running
+// service. An invalid configuration is rejected and the old one kept.
+type reloader struct {
+    path       string
+    applyFlags func(*config.Config) // command line flags override the file
+    current    *config.Config
+    client     *peertube.Client
+    handler    *watcher.UploadHandler
+    watcher    *watcher.Watcher
+    logger     *log.Logger
+    dryRun     bool // leave folders and the metadata cache alone
+
+    // reloading serializes reloads. It is held for the whole reload, while
+    // mu is only held to read and swap the settings, so talking to a slow
+    // server does not block supervise or shutdown.
+    reloading sync.Mutex
+
+    mu          sync.Mutex
+    ready       bool // authenticated and metadata fetched from the server
+    supervising bool // supervise is running
+}
+
+func (r *reloader) reload() {
+    r.reloading.Lock()
+    defer r.reloading.Unlock()
+
+    r.logger.Printf("Reloading configuration from: %s", r.path)
+
+    cfg, err := config.Load(r.path)
+    if err != nil {
+        r.logger.Printf("ERROR: Config reload failed, keeping current settings: %v", err)
+        return
+    }
+    r.applyFlags(cfg)
+
+    if err := cfg.Validate(); err != nil {
+        r.logger.Printf("ERROR: Invalid configuration, keeping current settings: %v", err)
+        return
+    }
+    if !r.dryRun {
+        if err := cfg.PrepareFolders(); err != nil {
+            r.logger.Printf("ERROR: Invalid configuration, keeping current settings: %v", err)
+            return
+        }
+    }
+
+    r.mu.Lock()
+    old, client := r.current, r.client
+    r.mu.Unlock()
+
+    // Only start a new session if the connection settings changed
+    if connectionChanged(old, cfg) {
+        client = newClient(cfg)
+        r.logger.Printf("PeerTube connection settings changed")
+    }
+
+    fresh, err := r.resolveMetadata(client, cfg, old)
+    if err != nil {
+        r.logger.Printf("ERROR: Invalid configuration, keeping current settings: %v", err)
+        return
+    }
+
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if err := r.watcher.Reconfigure(cfg.Watcher.WatchPath, cfg.Watcher.VideoExtensions, cfg.Watcher.SettleTime); err != nil {
+        r.logger.Printf("ERROR: Config reload failed, keeping current settings: %v", err)
+        return
+    }
+    r.watcher.SetReadiness(readiness(cfg))
+    r.watcher.SetFilter(fileFilter(cfg))
+    r.handler.Reconfigure(cfg, client)
+
+    if r.current.Watcher.Backend != cfg.Watcher.Backend || r.current.Watcher.PollInterval != cfg.Watcher.PollInterval {
+        r.logger.Printf("WARNING: Watcher backend settings changed; restart the service to apply them")
+    }
+    if !reflect.DeepEqual(r.current.Logging, cfg.Logging) {
+        r.logger.Printf("WARNING: Logging settings changed; restart the service to apply them")
+    }
+    if !reflect.DeepEqual(r.current.Notifications, cfg.Notifications) {
+        r.logger.Printf("WARNING: Notification settings changed; restart the service to apply them")
+    }
+
+    r.current = cfg
+    r.client = client
+    r.logger.Printf("Configuration reloaded")
+
+    switch {
+    case fresh && r.ready:
+        // Files held under the old settings, e.g. for a channel that isn't
+        // the user's, are tried again with the new ones
+        r.watcher.RetryHeld()
+    case fresh:
+        r.resume()
+    case !hasCredentials(cfg):
+        r.pause("PeerTube credentials not configured")
+    default:
+        r.pause("PeerTube server not reachable")
+    }
+}
+
+// resolveMetadata resolves category, licence, privacy and language names
+// and the channel, and reports whether the metadata came from the server.
+// If the server can't be reached, the cached metadata is used, or failing
+// that unchanged values are taken from the old configuration.
+func (r *reloader) resolveMetadata(client *peertube.Client, cfg, previous *config.Config) (bool, error) {
+    if hasCredentials(cfg) {
+        metadata, err := fetchMetadata(context.Background(), client, cfg, r.dryRun, r.logger)
+        if err == nil {
+            _, err := resolveDefaults(cfg, metadata)
+            return true, err
+        }
+        r.logger.Printf("WARNING: Failed to fetch metadata: %v", err)
+
+        if metadata, err := cachedMetadata(cfg); err == nil {
+            r.logger.Printf("Using metadata cached at %s", metadata.Fetched.Format(time.RFC3339))
+            _, err := resolveDefaults(cfg, metadata)
+            return false, err
+        }
+    }
+
+    old := previous.PeerTube.Defaults
+    defaults := &cfg.PeerTube.Defaults
+    if defaults.Channel != "" && defaults.Channel == old.Channel {
+        defaults.ChannelID = old.ChannelID
+    }
+    if bytes.Equal(old.CategoryRaw, defaults.CategoryRaw) {
+        defaults.Category = old.Category
+    }
+    if bytes.Equal(old.LicenceRaw, defaults.LicenceRaw) {
+        defaults.Licence = old.Licence
+    }
+    if bytes.Equal(old.PrivacyRaw, defaults.PrivacyRaw) {
+        defaults.Privacy = old.Privacy
+    }
+    if old.LanguageRaw == defaults.LanguageRaw {
+        defaults.Language = old.Language
+    }
+    defaults.Languages = old.Languages
+    return false, nil
+}
+
+// shutdownTimeout returns how long shutdown waits for active uploads
+func (r *reloader) shutdownTimeout() time.Duration {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return time.Duration(r.current.Watcher.ShutdownTimeout) * time.Second
+}
+
+func connectionChanged(old, cfg *config.Config) bool {
+    return old.PeerTube.URL != cfg.PeerTube.URL ||
+        old.PeerTube.Username != cfg.PeerTube.Username ||
+        old.PeerTube.Password != cfg.PeerTube.Password ||
+        old.PeerTube.UploadRateLimit != cfg.PeerTube.UploadRateLimit ||
+        old.PeerTube.Timeouts != cfg.PeerTube.Timeouts
+}
+
+// watchConfigFile calls reload after the config file is written. The folder
+// is watched rather than the file, since editors often save by replacing it.
+func watchConfigFile(path string, reload func(), logger *log.Logger) (func(), error) {
+    path, err := filepath.Abs(path)
+    if err != nil {
+        return nil, err
+    }
+
+    fsWatcher, err := fsnotify.NewWatcher()
+    if err != nil {
+        return nil, err
+    }
+    if err := fsWatcher.Add(filepath.Dir(path)); err != nil {
+        fsWatcher.Close()
+        return nil, err
+    }
+
+    go func() {
+        var timer *time.Timer
+        for {
+            select {
+            case event, ok := <-fsWatcher.Events:
+                if !ok {
+                    if timer != nil {
+                        timer.Stop()
+                    }
+                    return
+                }
+                if filepath.Clean(event.Name) != path {
+                    continue
+                }
+                if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+                    continue
+                }
+                if timer != nil {
+                    timer.Stop()
+                }
+                timer = time.AfterFunc(reloadDelay, reload)
+
+            case err, ok := <-fsWatcher.Errors:
+                if !ok {
+                    return
+                }
+                logger.Printf("ERROR: Config watcher error: %v", err)
+            }
+        }
+    }()
+
+    return func() { fsWatcher.Close() }, nil
+}
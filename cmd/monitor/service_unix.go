@@ -11,18 +11,44 @@ import (
     "github.com/dsu-teknik/peertube-monitor/pkg/watcher"
 )
 
-func runService(w *watcher.Watcher, logger *log.Logger) error {
+func runService(w *watcher.Watcher, reload *reloader, logger *log.Logger) (int, error) {
+    // Reload the configuration on SIGHUP
+    hupChan := make(chan os.Signal, 1)
+    signal.Notify(hupChan, syscall.SIGHUP)
+    defer signal.Stop(hupChan)
+
+    go func() {
+        for range hupChan {
+            logger.Printf("SIGHUP received")
+            reload.reload()
+        }
+    }()
+
     // Handle graceful shutdown
     sigChan := make(chan os.Signal, 1)
     signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+    defer signal.Stop(sigChan)
 
+    exitCode := make(chan int, 1)
     go func() {
         <-sigChan
         logger.Printf("Shutdown signal received, stopping...")
-        w.Stop()
-        os.Exit(0)
+
+        // A second signal skips the wait for active uploads
+        go func() {
+            <-sigChan
+            logger.Printf("Second signal received, cancelling active uploads")
+            w.Stop()
+        }()
+
+        exitCode <- shutdown(w, reload.shutdownTimeout(), logger)
     }()
 
-    // Start watching
-    return w.Start()
+    // Start watching; this returns once the watcher is shut down
+    if err := w.Start(); err != nil {
+        w.Stop()
+        return exitError, err
+    }
+
+    return <-exitCode, nil
 }
@@ -4,19 +4,26 @@ package main
 
 import (
     "log"
+    "time"
 
     "github.com/dsu-teknik/peertube-monitor/pkg/watcher"
     "golang.org/x/sys/windows/svc"
     "golang.org/x/sys/windows/svc/debug"
 )
 
+// stopCheckInterval is how often progress is reported to the service
+// manager while active uploads are drained
+const stopCheckInterval = 2 * time.Second
+
 type monitorService struct {
-    watcher *watcher.Watcher
-    logger  *log.Logger
+    watcher  *watcher.Watcher
+    reload   *reloader
+    logger   *log.Logger
+    exitCode int
 }
 
 func (m *monitorService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
-    const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown
+    const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange
 
     // Tell Windows we're starting
     changes <- svc.Status{State: svc.StartPending}
@@ -25,7 +32,7 @@ func (m *monitorService) Execute(args []string, r <-chan svc.ChangeRequest, chan
     errChan := make(chan error, 1)
     go func() {
         if err := m.watcher.Start(); err != nil {
-            m.logger.Printf("Watcher error: %v", err)
+            m.logger.Printf("ERROR: Watcher error: %v", err)
             errChan <- err
         }
     }()
@@ -42,38 +49,81 @@ loop:
             switch c.Cmd {
             case svc.Interrogate:
                 changes <- c.CurrentStatus
+            case svc.ParamChange:
+                // Sent by "sc control PeerTubeMonitor paramchange"
+                m.logger.Printf("Parameter change requested")
+                go m.reload.reload()
             case svc.Stop, svc.Shutdown:
                 m.logger.Printf("Service stop requested")
                 break loop
             default:
-                m.logger.Printf("Unexpected service control request #%d", c)
+                m.logger.Printf("WARNING: Unexpected service control request #%d", c)
             }
         case err := <-errChan:
-            m.logger.Printf("Watcher stopped with error: %v", err)
+            m.logger.Printf("ERROR: Watcher stopped with error: %v", err)
+            m.exitCode = exitError
             break loop
         }
     }
 
-    // Tell Windows we're stopping
-    changes <- svc.Status{State: svc.StopPending}
-    m.watcher.Stop()
+    // Tell Windows we're stopping, and keep reporting progress while active
+    // uploads are drained so the service manager doesn't give up on us
+    shutdownTimeout := m.reload.shutdownTimeout()
+    waitHint := uint32((shutdownTimeout + stopCheckInterval*2) / time.Millisecond)
+    checkPoint := uint32(1)
+    changes <- svc.Status{State: svc.StopPending, CheckPoint: checkPoint, WaitHint: waitHint}
+
+    done := make(chan int, 1)
+    go func() {
+        done <- shutdown(m.watcher, shutdownTimeout, m.logger)
+    }()
+
+    ticker := time.NewTicker(stopCheckInterval)
+    defer ticker.Stop()
+
+drain:
+    for {
+        select {
+        case code := <-done:
+            if m.exitCode == exitOK {
+                m.exitCode = code
+            }
+            break drain
+        case <-ticker.C:
+            checkPoint++
+            changes <- svc.Status{State: svc.StopPending, CheckPoint: checkPoint, WaitHint: waitHint}
+        case c := <-r:
+            if c.Cmd == svc.Interrogate {
+                changes <- svc.Status{State: svc.StopPending, CheckPoint: checkPoint, WaitHint: waitHint}
+            }
+        }
+    }
+
     m.logger.Printf("Service stopped")
 
-    return
+    // Report a non-zero exit code as service-specific
+    if m.exitCode != exitOK {
+        return true, uint32(m.exitCode)
+    }
+    return false, 0
 }
 
-func runService(w *watcher.Watcher, logger *log.Logger) error {
+func runService(w *watcher.Watcher, reload *reloader, logger *log.Logger) (int, error) {
     // Check if we're running as a service or interactively
     isService, err := svc.IsWindowsService()
     if err != nil {
-        return err
+        return exitError, err
     }
 
+    service := &monitorService{watcher: w, reload: reload, logger: logger}
+
     if !isService {
         // Running interactively (e.g., from command line for testing)
-        return debug.Run("PeerTubeMonitor", &monitorService{watcher: w, logger: logger})
+        err = debug.Run(serviceName, service)
+        return service.exitCode, err
     }
 
     // Running as a Windows service
-    return svc.Run("PeerTubeMonitor", &monitorService{watcher: w, logger: logger})
+    err = svc.Run(serviceName, service)
+    return service.exitCode, err
 }
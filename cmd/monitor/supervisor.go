@@ -0,0 +1,116 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/peertube"
+)
+
+// While the server isn't ready it is tried again after serverRetryMinDelay,
+// doubling each attempt up to serverRetryMaxDelay
+const (
+    serverRetryMinDelay = 5 * time.Second
+    serverRetryMaxDelay = 5 * time.Minute
+)
+
+// pause holds all files with reason and starts supervise to find out when
+// the server is ready. r.mu must be held.
+func (r *reloader) pause(reason string) {
+    if r.ready || !r.supervising {
+        r.logger.Printf("Uploads paused: %s; retrying in the background", reason)
+    }
+    r.ready = false
+    r.handler.Pause(reason)
+
+    if !r.supervising {
+        r.supervising = true
+        go r.supervise()
+    }
+}
+
+// serverDown pauses uploads when an upload finds the server unreachable or
+// the credentials rejected
+func (r *reloader) serverDown(reason string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.pause(reason)
+}
+
+// resume uploads the held files once the server is ready. r.mu must be
+// held.
+func (r *reloader) resume() {
+    if r.ready {
+        return
+    }
+    r.ready = true
+    r.handler.Resume()
+    r.watcher.RetryHeld()
+    r.logger.Printf("PeerTube server ready; uploads resumed")
+}
+
+// supervise retries authentication and fetching the metadata with backoff
+// until both succeed or a reload makes the server ready
+func (r *reloader) supervise() {
+    delay := serverRetryMinDelay
+    for {
+        time.Sleep(delay)
+        done, err := r.checkServer()
+        if done {
+            return
+        }
+
+        delay = min(delay*2, serverRetryMaxDelay)
+        r.logger.Printf("WARNING: PeerTube server not ready: %v (next attempt in %s)", err, delay)
+    }
+}
+
+// checkServer authenticates and fetches the metadata once. On success the
+// current configuration is resolved against it and uploads resume. It
+// returns true when supervise is done.
+func (r *reloader) checkServer() (bool, error) {
+    r.mu.Lock()
+    current, client, ready := r.current, r.client, r.ready
+    if ready {
+        r.supervising = false
+    }
+    r.mu.Unlock()
+    if ready {
+        return true, nil
+    }
+
+    // Talk to the server without holding the lock, so a slow server does
+    // not block reloads or shutdown
+    var metadata *peertube.MetadataCache
+    err := errors.New("credentials not configured")
+    if hasCredentials(current) {
+        ctx := context.Background()
+        if err = client.Authenticate(ctx); err == nil {
+            metadata, err = fetchMetadata(ctx, client, current, r.dryRun, r.logger)
+        }
+    }
+
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.ready {
+        r.supervising = false
+        return true, nil
+    }
+    if err != nil {
+        return false, err
+    }
+    if r.current != current {
+        return false, errors.New("configuration reloaded during the check")
+    }
+
+    cfg := *current
+    if _, err := resolveDefaults(&cfg, metadata); err != nil {
+        r.logger.Printf("WARNING: Invalid configuration: %v", err)
+    }
+    r.handler.Reconfigure(&cfg, client)
+    r.current = &cfg
+    r.supervising = false
+    r.resume()
+    return true, nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+    "github.com/dsu-teknik/peertube-monitor/pkg/notify"
+    "github.com/dsu-teknik/peertube-monitor/pkg/watcher"
+)
+
+// uploadOutput is one file in the JSON output of the upload command
+type uploadOutput struct {
+    File   string       `json:"file"`
+    Status string       `json:"status"`
+    UUID   string       `json:"uuid,omitempty"`
+    URL    string       `json:"url,omitempty"`
+    Dest   string       `json:"dest,omitempty"`
+    Error  string       `json:"error,omitempty"`
+    Video  *videoOutput `json:"video,omitempty"`
+}
+
+type videoOutput struct {
+    Name            string   `json:"name"`
+    ChannelID       int      `json:"channelId"`
+    Category        int      `json:"category"`
+    Licence         int      `json:"licence"`
+    Language        string   `json:"language"`
+    Privacy         int      `json:"privacy"`
+    Description     string   `json:"description,omitempty"`
+    Tags            []string `json:"tags"`
+    DownloadEnabled bool     `json:"downloadEnabled"`
+    CommentsEnabled bool     `json:"commentsEnabled"`
+    WaitTranscoding bool     `json:"waitTranscoding"`
+    NSFW            bool     `json:"nsfw"`
+}
+
+func runUpload(args []string) int {
+    fs := flag.NewFlagSet("upload", flag.ExitOnError)
+    fs.Usage = func() {
+        fmt.Fprintf(fs.Output(), "Usage: peertube-monitor upload [flags] <file or folder>...\n\nFlags:\n")
+        fs.PrintDefaults()
+    }
+    configPath := fs.String("config", "config.json", "Path to configuration file")
+    jsonOutput := fs.Bool("json", false, "Print the results as JSON")
+    dryRun := fs.Bool("dry-run", false, "Show what would be uploaded without uploading")
+    wait := fs.Bool("wait", false, "Hold and retry files like the service does instead of failing them")
+    move := fs.Bool("move", false, "Move files to the done/failed folders (default: leave them in place)")
+
+    // Overrides for peertube.defaults
+    name := fs.String("name", "", "Title template")
+    description := fs.String("description", "", "Description template")
+    channel := fs.String("channel", "", "Channel, by ID, handle or display name")
+    category := fs.String("category", "", "Category, by name or ID")
+    licence := fs.String("licence", "", "Licence, by name or ID")
+    privacy := fs.String("privacy", "", "Privacy, by name or ID")
+    language := fs.String("language", "", "Language, by code or name")
+    tags := fs.String("tags", "", "Comma-separated tags")
+    nsfw := fs.Bool("nsfw", false, "Mark as NSFW")
+    downloadEnabled := fs.Bool("download-enabled", false, "Allow downloads")
+    commentsEnabled := fs.Bool("comments-enabled", false, "Allow comments")
+    waitTranscoding := fs.Bool("wait-transcoding", false, "Publish only after transcoding")
+    fs.Parse(args)
+
+    if fs.NArg() == 0 {
+        fs.Usage()
+        return exitError
+    }
+
+    logger := log.New(os.Stderr, "", log.LstdFlags)
+
+    cfg, err := config.Load(*configPath)
+    if err != nil {
+        logger.Printf("ERROR: Failed to load config: %v", err)
+        return exitError
+    }
+
+    // Flags given on the command line replace the configured defaults
+    defaults := &cfg.PeerTube.Defaults
+    fs.Visit(func(f *flag.Flag) {
+        switch f.Name {
+        case "name":
+            defaults.Name = *name
+        case "description":
+            defaults.Description = *description
+        case "channel":
+            if id, err := strconv.Atoi(*channel); err == nil {
+                defaults.Channel, defaults.ChannelID = "", id
+            } else {
+                defaults.Channel, defaults.ChannelID = *channel, 0
+            }
+        case "category":
+            defaults.CategoryRaw = rawIDOrName(*category)
+        case "licence":
+            defaults.LicenceRaw = rawIDOrName(*licence)
+        case "privacy":
+            defaults.PrivacyRaw = rawIDOrName(*privacy)
+        case "language":
+            defaults.LanguageRaw = *language
+        case "tags":
+            defaults.Tags = splitList(*tags)
+        case "nsfw":
+            defaults.NSFW = *nsfw
+        case "download-enabled":
+            defaults.DownloadEnabled = *downloadEnabled
+        case "comments-enabled":
+            defaults.CommentsEnabled = *commentsEnabled
+        case "wait-transcoding":
+            defaults.WaitTranscoding = *waitTranscoding
+        }
+    })
+
+    // Files are not taken from the watch folder, so only the settings for
+    // uploading them, and the done and failed folders when moving them,
+    // need to be right
+    if err := cfg.ValidateUpload(); err != nil {
+        logger.Printf("ERROR: Invalid configuration: %v", err)
+        return exitError
+    }
+    if *move && !*dryRun {
+        if err := cfg.PrepareDestinations(); err != nil {
+            logger.Printf("ERROR: Invalid configuration: %v", err)
+            return exitError
+        }
+    }
+
+    files, err := expandUploadArgs(fs.Args(), fileFilter(cfg), cfg.Watcher.VideoExtensions)
+    if err != nil {
+        logger.Printf("ERROR: %v", err)
+        return exitError
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer stop()
+
+    client := newClient(cfg)
+    metadata, err := client.FetchMetadata(ctx)
+    if err != nil {
+        logger.Printf("ERROR: Failed to fetch metadata: %v", err)
+        return exitError
+    }
+    if err := cfg.ResolveMetadata(metadata.Categories, metadata.Licences, metadata.Privacies, metadata.Languages); err != nil {
+        logger.Printf("ERROR: Invalid configuration: %v", err)
+        return exitError
+    }
+    channels, err := client.ListChannels(ctx)
+    if err != nil {
+        logger.Printf("ERROR: Failed to list channels: %v", err)
+        return exitError
+    }
+    if _, err := cfg.ResolveChannel(channels); err != nil {
+        logger.Printf("ERROR: Invalid configuration: %v", err)
+        return exitError
+    }
+
+    // A dry run sends no notifications
+    var notifier notify.Notifier
+    if !*dryRun {
+        dispatcher, err := notify.New(cfg, logger)
+        if err != nil {
+            logger.Printf("ERROR: Failed to set up notifications: %v", err)
+            return exitError
+        }
+        defer dispatcher.Close()
+        notifier = dispatcher
+    }
+
+    handler := watcher.NewUploadHandler(client, cfg, notifier, logger)
+    handler.SetKeepFiles(!*move)
+    handler.SetDryRun(*dryRun)
+
+    code := exitOK
+    var outputs []uploadOutput
+    for _, file := range files {
+        result, err := uploadFile(ctx, handler, file, *wait, logger)
+        out := uploadOutput{File: file}
+        switch {
+        case ctx.Err() != nil:
+            out.Status, out.Error = watcher.StatusInterrupted, ctx.Err().Error()
+            code = exitInterrupted
+        case err != nil:
+            out.Status, out.Error = watcher.StatusFailed, err.Error()
+            code = exitError
+        default:
+            out.Status, out.UUID, out.URL, out.Dest, out.Error = result.Status, result.UUID, result.URL, result.Dest, result.Error
+            if result.Status == watcher.StatusFailed {
+                code = exitError
+            }
+            if result.Status == watcher.StatusUploaded || result.Status == watcher.StatusDryRun {
+                a := result.Attributes
+                out.Video = &videoOutput{
+                    Name:            a.Name,
+                    ChannelID:       a.ChannelID,
+                    Category:        a.Category,
+                    Licence:         a.Licence,
+                    Language:        a.Language,
+                    Privacy:         a.Privacy,
+                    Description:     a.Description,
+                    Tags:            a.Tags,
+                    DownloadEnabled: a.DownloadEnabled,
+                    CommentsEnabled: a.CommentsEnabled,
+                    WaitTranscoding: a.WaitTranscoding,
+                    NSFW:            a.NSFW,
+                }
+            }
+        }
+        outputs = append(outputs, out)
+
+        if ctx.Err() != nil {
+            break
+        }
+    }
+
+    if *jsonOutput {
+        encoder := json.NewEncoder(os.Stdout)
+        encoder.SetIndent("", "  ")
+        encoder.Encode(outputs)
+    } else {
+        for _, out := range outputs {
+            switch {
+            case out.URL != "":
+                fmt.Printf("%-11s %s -> %s\n", out.Status, out.File, out.URL)
+            case out.Error != "":
+                fmt.Printf("%-11s %s: %s\n", out.Status, out.File, out.Error)
+            default:
+                fmt.Printf("%-11s %s\n", out.Status, out.File)
+            }
+        }
+    }
+
+    return code
+}
+
+// uploadFile uploads one file. Deferred files fail unless wait is set, in
+// which case they are retried when the handler asks.
+func uploadFile(ctx context.Context, handler *watcher.UploadHandler, path string, wait bool, logger *log.Logger) (*watcher.Result, error) {
+    for {
+        result, err := handler.HandleFile(ctx, path)
+
+        var deferErr *watcher.DeferError
+        if !wait || !errors.As(err, &deferErr) {
+            return result, err
+        }
+
+        logger.Printf("Holding %s: %s (next attempt in %s)", path, deferErr.Reason, deferErr.RetryAfter)
+        select {
+        case <-time.After(deferErr.RetryAfter):
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        }
+    }
+}
+
+// expandUploadArgs replaces folders by the video files directly inside
+// them, selected by name like the watcher does
+func expandUploadArgs(args []string, filter watcher.Filter, extensions []string) ([]string, error) {
+    var files []string
+    for _, arg := range args {
+        info, err := os.Stat(arg)
+        if err != nil {
+            return nil, err
+        }
+        if !info.IsDir() {
+            files = append(files, arg)
+            continue
+        }
+
+        entries, err := os.ReadDir(arg)
+        if err != nil {
+            return nil, err
+        }
+        var found []string
+        for _, entry := range entries {
+            if ok, _ := filter.MatchName(entry.Name(), extensions); ok && !entry.IsDir() {
+                found = append(found, filepath.Join(arg, entry.Name()))
+            }
+        }
+        sort.Strings(found)
+        files = append(files, found...)
+    }
+    return files, nil
+}
+
+// rawIDOrName encodes a command line value like it would appear in the
+// config file: a number as an ID, anything else as a name
+func rawIDOrName(value string) json.RawMessage {
+    if id, err := strconv.Atoi(value); err == nil {
+        return json.RawMessage(strconv.Itoa(id))
+    }
+    data, _ := json.Marshal(value)
+    return data
+}
+
+func splitList(value string) []string {
+    var items []string
+    for _, item := range strings.Split(value, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            items = append(items, item)
+        }
+    }
+    return items
+}
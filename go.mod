@@ -4,4 +4,11 @@ go 1.21
 
 require github.com/fsnotify/fsnotify v1.7.0
 
-require golang.org/x/sys v0.4.0
+require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/coreos/go-systemd/v22 v22.5.0
+	golang.org/x/sys v0.10.0
+	golang.org/x/term v0.10.0
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,63 @@
+package config
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/peertube"
+)
+
+// ResolveChannel sets Defaults.ChannelID from the channel setting, which
+// may be a handle ("my_channel" or "my_channel@host") or a display name.
+// The channel must be one of the user's own channels; without either
+// setting the user's first channel is used.
+func (c *Config) ResolveChannel(channels []peertube.Channel) (*peertube.Channel, error) {
+    channel, err := FindChannel(channels, c.PeerTube.Defaults.Channel, c.PeerTube.Defaults.ChannelID)
+    if err != nil {
+        return nil, err
+    }
+    c.PeerTube.Defaults.ChannelID = channel.ID
+    return channel, nil
+}
+
+// FindChannel picks a channel from the user's channels by handle or
+// display name (case-insensitive), or by ID when ref is empty
+func FindChannel(channels []peertube.Channel, ref string, id int) (*peertube.Channel, error) {
+    if len(channels) == 0 {
+        return nil, fmt.Errorf("channel: user has no video channels")
+    }
+
+    switch {
+    case ref != "":
+        handle, _, _ := strings.Cut(strings.TrimPrefix(ref, "@"), "@")
+        for i := range channels {
+            if equalFold(channels[i].Name, handle) {
+                return &channels[i], nil
+            }
+        }
+        for i := range channels {
+            if strings.EqualFold(channels[i].DisplayName, ref) {
+                return &channels[i], nil
+            }
+        }
+        return nil, fmt.Errorf("channel: %q is not one of your channels. Available options: %s", ref, formatChannels(channels))
+
+    case id != 0:
+        for i := range channels {
+            if channels[i].ID == id {
+                return &channels[i], nil
+            }
+        }
+        return nil, fmt.Errorf("channelId: %d is not one of your channels. Available options: %s", id, formatChannels(channels))
+    }
+
+    return &channels[0], nil
+}
+
+func formatChannels(channels []peertube.Channel) string {
+    var items []string
+    for _, channel := range channels {
+        items = append(items, fmt.Sprintf("%d=%q (%s)", channel.ID, channel.DisplayName, channel.Name))
+    }
+    return "[" + joinStrings(items, ", ") + "]"
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/peertube"
+)
+
+func TestFindChannel(t *testing.T) {
+    channels := []peertube.Channel{
+        {ID: 7, Name: "main_channel", DisplayName: "Main Channel"},
+        {ID: 9, Name: "sports", DisplayName: "Sports Stuff"},
+    }
+
+    tests := []struct {
+        name string
+        ref  string
+        id   int
+        want int    // channel ID
+        err  string // "" = found
+    }{
+        {"first by default", "", 0, 7, ""},
+        {"handle", "sports", 0, 9, ""},
+        {"handle with host", "sports@peertube.example.com", 0, 9, ""},
+        {"handle with @", "@sports", 0, 9, ""},
+        {"handle in any case", "SPORTS", 0, 9, ""},
+        {"display name", "sports stuff", 0, 9, ""},
+        {"id", "", 9, 9, ""},
+        {"unknown handle", "news", 0, 0, `"news" is not one of your channels`},
+        {"unknown id", "", 3, 0, "channelId: 3 is not one of your channels"},
+    }
+    for _, tt := range tests {
+        channel, err := FindChannel(channels, tt.ref, tt.id)
+        if tt.err != "" {
+            if err == nil || !strings.Contains(err.Error(), tt.err) {
+                t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+            continue
+        }
+        if channel.ID != tt.want {
+            t.Errorf("%s: channel %d, want %d", tt.name, channel.ID, tt.want)
+        }
+    }
+
+    // Errors list the choices
+    _, err := FindChannel(channels, "news", 0)
+    if err == nil || !strings.Contains(err.Error(), `9="Sports Stuff" (sports)`) {
+        t.Errorf("err = %v, want the available channels", err)
+    }
+
+    if _, err := FindChannel(nil, "", 0); err == nil {
+        t.Error("no channels: expected an error")
+    }
+}
+
+func TestResolveChannelSetsID(t *testing.T) {
+    cfg := validConfig()
+    cfg.PeerTube.Defaults.Channel = "sports"
+    channel, err := cfg.ResolveChannel([]peertube.Channel{{ID: 7, Name: "main"}, {ID: 9, Name: "sports"}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if channel.ID != 9 || cfg.PeerTube.Defaults.ChannelID != 9 {
+        t.Errorf("channel %d, channelId %d, want 9", channel.ID, cfg.PeerTube.Defaults.ChannelID)
+    }
+}
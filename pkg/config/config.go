@@ -4,13 +4,24 @@ import (
     "encoding/json"
     "fmt"
     "os"
+    "net/url"
     "path/filepath"
+    "reflect"
     "sort"
+    "strings"
+    "text/template"
+    "time"
 )
 
 type Config struct {
     PeerTube PeerTubeConfig `json:"peertube"`
     Watcher  WatcherConfig  `json:"watcher"`
+    Logging  LoggingConfig  `json:"logging"`
+
+    Notifications NotificationsConfig `json:"notifications"`
+
+    // Problems found by Load, reported by Validate
+    loadErrors []error
 }
 
 type PeerTubeConfig struct {
@@ -18,13 +29,26 @@ type PeerTubeConfig struct {
     Username string        `json:"username"`
     Password string        `json:"password"`
     Defaults VideoDefaults `json:"defaults"`
+
+    UploadRateLimit int64          `json:"uploadRateLimit"` // bytes per second (0 = unlimited)
+    Timeouts        TimeoutsConfig `json:"timeouts"`
+    MetadataCache   string         `json:"metadataCache"` // file for metadata used while the server is down
+}
+
+type TimeoutsConfig struct {
+    Connect  int `json:"connect"`  // seconds to connect to the server
+    Idle     int `json:"idle"`     // seconds without upload progress or a response
+    Response int `json:"response"` // seconds to wait for the server once an upload is sent (0 = no limit)
+    Total    int `json:"total"`    // seconds for a whole request (0 = no limit)
 }
 
 type VideoDefaults struct {
     ChannelID          int             `json:"channelId,omitempty"`
+    Channel            string          `json:"channel"` // handle or display name, instead of channelId
+    Name               string          `json:"name"` // title template (default: file name without extension)
     CategoryRaw        json.RawMessage `json:"category"`
     LicenceRaw         json.RawMessage `json:"licence"`
-    Language           string          `json:"language"`
+    LanguageRaw        string          `json:"language"` // code or name
     PrivacyRaw         json.RawMessage `json:"privacy"`
     Description        string          `json:"description"`
     Tags               []string        `json:"tags"`
@@ -37,6 +61,13 @@ type VideoDefaults struct {
     Category int `json:"-"`
     Licence  int `json:"-"`
     Privacy  int `json:"-"`
+
+    // Resolved language code
+    Language string `json:"-"`
+
+    // Languages known to the server, for resolving languages set by hooks
+    // (nil until metadata has been fetched)
+    Languages map[string]string `json:"-"`
 }
 
 type WatcherConfig struct {
@@ -46,6 +77,91 @@ type WatcherConfig struct {
     VideoExtensions []string `json:"videoExtensions"`
     SettleTime     int      `json:"settleTime"` // seconds to wait for file to stop changing
     MaxRetries     int      `json:"maxRetries"`
+    Hooks          HooksConfig `json:"hooks"`
+    Validation     ValidationConfig `json:"validation"`
+    UploadWindows  []string `json:"uploadWindows"` // local times uploads may start, "HH:MM-HH:MM" (empty = any time)
+    ShutdownTimeout int     `json:"shutdownTimeout"` // seconds to let active uploads finish on shutdown
+    Backend        string   `json:"backend"`      // "fsnotify", "poll" or "auto" (poll on network filesystems)
+    PollInterval   int      `json:"pollInterval"` // seconds between scans with the poll backend
+    Readiness      ReadinessConfig `json:"readiness"`
+    Filter         FilterConfig    `json:"filter"`
+}
+
+// FilterConfig selects which files in the watch folder are uploaded.
+// Rejected files are left where they are.
+type FilterConfig struct {
+    Include      []string `json:"include"`      // glob patterns for file names (empty = videoExtensions)
+    Exclude      []string `json:"exclude"`      // glob patterns for file names to ignore, e.g. "*_proxy.mp4"
+    MinSize      int64    `json:"minSize"`      // bytes (0 = no limit)
+    MaxSize      int64    `json:"maxSize"`      // bytes (0 = no limit)
+    SniffContent bool     `json:"sniffContent"` // check that the first bytes look like a video
+}
+
+// ReadinessConfig selects the checks a file in the watch folder must pass,
+// after it stops changing, before it is uploaded. All enabled checks apply.
+type ReadinessConfig struct {
+    StableChecks int    `json:"stableChecks"` // settle periods in a row without changes (default 1)
+    NotInUse     bool   `json:"notInUse"`     // wait until no other process has the file open
+    MarkerSuffix string `json:"markerSuffix"` // wait for a marker file, e.g. ".done" for video.mp4.done
+    MinAge       int    `json:"minAge"`       // seconds since the last modification
+}
+
+type ValidationConfig struct {
+    Enabled     bool    `json:"enabled"`
+    FFprobePath string  `json:"ffprobePath"`
+    MinDuration float64 `json:"minDuration"` // seconds
+    MaxDuration float64 `json:"maxDuration"` // seconds (0 = no limit)
+    Timeout     int     `json:"timeout"`     // seconds before ffprobe is killed
+}
+
+type HooksConfig struct {
+    PreUpload   HookConfig `json:"preUpload"`
+    PostSuccess HookConfig `json:"postSuccess"`
+    PostFailure HookConfig `json:"postFailure"`
+}
+
+type HookConfig struct {
+    Command []string `json:"command"` // program and arguments (empty = disabled)
+    Timeout int      `json:"timeout"` // seconds before the command is killed
+    OnError string   `json:"onError"` // pre-upload only: "fail" (default), "skip" or "ignore"
+}
+
+type LoggingConfig struct {
+    LogFile    string `json:"logFile"`
+    Verbose    bool   `json:"verbose"`
+    MaxSizeMB  int    `json:"maxSizeMB"`  // rotate the log file when it reaches this size
+    MaxAgeDays int    `json:"maxAgeDays"` // remove rotated files older than this (0 = no age limit)
+    MaxBackups int    `json:"maxBackups"` // number of rotated files to keep (-1 = keep all)
+    Compress   bool   `json:"compress"`   // gzip rotated files
+    Journald   bool   `json:"journald"`   // also log to journald when running under systemd
+    EventLog   bool   `json:"eventLog"`   // also log to the Windows Event Log when running as a service
+}
+
+type NotificationsConfig struct {
+    Webhooks []WebhookConfig `json:"webhooks"`
+    Email    EmailConfig     `json:"email"`
+}
+
+type WebhookConfig struct {
+    URL        string            `json:"url"`
+    Events     []string          `json:"events"`   // event types to send (empty = all)
+    Template   string            `json:"template"` // text/template for the JSON body (empty = default payload)
+    Secret     string            `json:"secret"`   // HMAC-SHA256 signing key
+    Headers    map[string]string `json:"headers"`
+    MaxRetries *int              `json:"maxRetries"` // delivery retries (unset = 5, 0 = none)
+    Timeout    int               `json:"timeout"`    // seconds per delivery attempt
+}
+
+type EmailConfig struct {
+    Host       string   `json:"host"` // SMTP server (empty = email disabled)
+    Port       int      `json:"port"`
+    Username   string   `json:"username"`
+    Password   string   `json:"password"`
+    From       string   `json:"from"`
+    To         []string `json:"to"`
+    TLS        string   `json:"tls"`        // "starttls" (default), "tls" or "none"
+    Digest     bool     `json:"digest"`     // send a daily summary
+    DigestTime string   `json:"digestTime"` // local time of day for the digest, "HH:MM"
 }
 
 func Load(path string) (*Config, error) {
@@ -54,21 +170,99 @@ func Load(path string) (*Config, error) {
         return nil, fmt.Errorf("reading config file: %w", err)
     }
 
+    raw, err := decodeFile(path, data)
+    if err != nil {
+        return nil, fmt.Errorf("parsing config file: %w", err)
+    }
+
+    // Misspelled keys and values of the wrong type are kept for Validate,
+    // so they are reported together with the other problems
     var cfg Config
-    if err := json.Unmarshal(data, &cfg); err != nil {
+    cfg.loadErrors = checkFields(raw, reflect.TypeOf(Config{}), "")
+
+    // Expand environment variables, then decode through JSON so every format
+    // shares the same field names and types
+    typeErrs, err := decodeConfig(interpolate(raw).(map[string]interface{}), &cfg)
+    if err != nil {
         return nil, fmt.Errorf("parsing config file: %w", err)
     }
+    cfg.loadErrors = append(cfg.loadErrors, typeErrs...)
 
     // Set defaults
+    cfg.PeerTube.Defaults.Language = cfg.PeerTube.Defaults.LanguageRaw // used as is until resolved
     if cfg.Watcher.SettleTime == 0 {
         cfg.Watcher.SettleTime = 5
     }
     if cfg.Watcher.MaxRetries == 0 {
         cfg.Watcher.MaxRetries = 3
     }
+    if cfg.Watcher.ShutdownTimeout == 0 {
+        cfg.Watcher.ShutdownTimeout = 30
+    }
+    if cfg.Watcher.Backend == "" {
+        cfg.Watcher.Backend = "auto"
+    }
+    if cfg.Watcher.PollInterval == 0 {
+        cfg.Watcher.PollInterval = 10
+    }
+    if cfg.Watcher.Readiness.StableChecks == 0 {
+        cfg.Watcher.Readiness.StableChecks = 1
+    }
     if len(cfg.Watcher.VideoExtensions) == 0 {
         cfg.Watcher.VideoExtensions = []string{".mp4", ".webm", ".mkv", ".avi", ".mov", ".flv"}
     }
+    for _, hook := range []*HookConfig{&cfg.Watcher.Hooks.PreUpload, &cfg.Watcher.Hooks.PostSuccess, &cfg.Watcher.Hooks.PostFailure} {
+        if hook.Timeout == 0 {
+            hook.Timeout = 60
+        }
+        if hook.OnError == "" {
+            hook.OnError = "fail"
+        }
+    }
+    if cfg.PeerTube.Timeouts.Connect == 0 {
+        cfg.PeerTube.Timeouts.Connect = 30
+    }
+    if cfg.PeerTube.Timeouts.Idle == 0 {
+        cfg.PeerTube.Timeouts.Idle = 120
+    }
+    if cfg.PeerTube.MetadataCache == "" {
+        if dir, err := os.UserCacheDir(); err == nil {
+            cfg.PeerTube.MetadataCache = filepath.Join(dir, "peertube-monitor", "metadata.json")
+        }
+    }
+    if cfg.Watcher.Validation.FFprobePath == "" {
+        cfg.Watcher.Validation.FFprobePath = "ffprobe"
+    }
+    if cfg.Watcher.Validation.MinDuration == 0 {
+        cfg.Watcher.Validation.MinDuration = 1
+    }
+    if cfg.Watcher.Validation.Timeout == 0 {
+        cfg.Watcher.Validation.Timeout = 60
+    }
+    if cfg.Logging.MaxSizeMB == 0 {
+        cfg.Logging.MaxSizeMB = 100
+    }
+    if cfg.Logging.MaxBackups == 0 {
+        cfg.Logging.MaxBackups = 5
+    }
+    if cfg.Notifications.Email.Port == 0 {
+        cfg.Notifications.Email.Port = 587
+    }
+    if cfg.Notifications.Email.TLS == "" {
+        cfg.Notifications.Email.TLS = "starttls"
+    }
+    if cfg.Notifications.Email.DigestTime == "" {
+        cfg.Notifications.Email.DigestTime = "08:00"
+    }
+    for i := range cfg.Notifications.Webhooks {
+        if cfg.Notifications.Webhooks[i].MaxRetries == nil {
+            retries := 5
+            cfg.Notifications.Webhooks[i].MaxRetries = &retries
+        }
+        if cfg.Notifications.Webhooks[i].Timeout == 0 {
+            cfg.Notifications.Webhooks[i].Timeout = 10
+        }
+    }
 
     // Override with environment variables if present
     cfg.loadFromEnv()
@@ -83,6 +277,12 @@ func Load(path string) (*Config, error) {
     if cfg.Watcher.FailedPath != "" && !filepath.IsAbs(cfg.Watcher.FailedPath) {
         cfg.Watcher.FailedPath, _ = filepath.Abs(cfg.Watcher.FailedPath)
     }
+    if cfg.Logging.LogFile != "" && !filepath.IsAbs(cfg.Logging.LogFile) {
+        cfg.Logging.LogFile, _ = filepath.Abs(cfg.Logging.LogFile)
+    }
+    if cfg.PeerTube.MetadataCache != "" && !filepath.IsAbs(cfg.PeerTube.MetadataCache) {
+        cfg.PeerTube.MetadataCache, _ = filepath.Abs(cfg.PeerTube.MetadataCache)
+    }
 
     return &cfg, nil
 }
@@ -98,6 +298,9 @@ func (c *Config) loadFromEnv() {
     if url := os.Getenv("PEERTUBE_URL"); url != "" {
         c.PeerTube.URL = url
     }
+    if smtpPassword := os.Getenv("PEERTUBE_SMTP_PASSWORD"); smtpPassword != "" {
+        c.Notifications.Email.Password = smtpPassword
+    }
 }
 
 func (c *Config) GetCredentialSource() string {
@@ -113,27 +316,336 @@ func (c *Config) GetCredentialSource() string {
     return "config file"
 }
 
+// Validate checks the configuration without touching the filesystem; see
+// PrepareFolders. All problems found are reported together as Errors.
 func (c *Config) Validate() error {
-    // Only validate critical structural settings
-    // Credentials are validated at authentication time, not startup
+    errs := append([]error(nil), c.loadErrors...)
+    errs = append(errs, c.validatePeerTube()...)
+    errs = append(errs, c.validateHandling()...)
+    errs = append(errs, c.validateWatching()...)
+    errs = append(errs, c.validateNotifications()...)
+    if c.Logging.MaxBackups < -1 {
+        errs = append(errs, fmt.Errorf("logging.maxBackups must be -1 (keep all) or more"))
+    }
+    return errorsOrNil(errs)
+}
+
+// ValidateUpload checks the settings used to upload files by hand rather
+// than from the watch folder: the peertube section and how files are
+// handled, but not the watch folder or how it is watched. See
+// PrepareDestinations for files moved to the done and failed folders.
+func (c *Config) ValidateUpload() error {
+    errs := append([]error(nil), c.loadErrors...)
+    errs = append(errs, c.validatePeerTube()...)
+    errs = append(errs, c.validateHandling()...)
+    errs = append(errs, c.validateNotifications()...)
+    return errorsOrNil(errs)
+}
+
+// errorList collects validation errors
+type errorList []error
+
+func (l *errorList) add(format string, args ...interface{}) {
+    *l = append(*l, fmt.Errorf(format, args...))
+}
+
+// setting is a numeric setting and its name in the config file
+type setting struct {
+    name  string
+    value float64
+}
+
+// notNegative adds an error for each negative setting
+func (l *errorList) notNegative(settings []setting) {
+    for _, s := range settings {
+        if s.value < 0 {
+            l.add("%s must not be negative", s.name)
+        }
+    }
+}
+
+// validatePeerTube checks the peertube section. Credentials are checked
+// when authenticating, not here.
+func (c *Config) validatePeerTube() []error {
+    var errs errorList
+
+    if c.PeerTube.Defaults.Channel != "" && c.PeerTube.Defaults.ChannelID != 0 {
+        errs.add("peertube.defaults: set either channel or channelId, not both")
+    }
+
+    if c.PeerTube.URL != "" {
+        if err := validateURL(c.PeerTube.URL); err != nil {
+            errs.add("peertube.url: %v", err)
+        }
+    }
+
+    if _, err := template.New("name").Parse(c.PeerTube.Defaults.Name); err != nil {
+        errs.add("peertube.defaults.name: invalid template: %w", err)
+    }
+    if _, err := template.New("description").Parse(c.PeerTube.Defaults.Description); err != nil {
+        errs.add("peertube.defaults.description: invalid template: %w", err)
+    }
+
+    errs.notNegative([]setting{
+        {"peertube.uploadRateLimit", float64(c.PeerTube.UploadRateLimit)},
+        {"peertube.timeouts.connect", float64(c.PeerTube.Timeouts.Connect)},
+        {"peertube.timeouts.idle", float64(c.PeerTube.Timeouts.Idle)},
+        {"peertube.timeouts.response", float64(c.PeerTube.Timeouts.Response)},
+        {"peertube.timeouts.total", float64(c.PeerTube.Timeouts.Total)},
+    })
+
+    return errs
+}
+
+// validateHandling checks the watcher settings for what happens to a file
+// once it is picked up: hooks, validation, filters, retries and upload
+// windows
+func (c *Config) validateHandling() []error {
+    var errs errorList
+
+    for _, ext := range c.Watcher.VideoExtensions {
+        if err := validateExtension(ext); err != nil {
+            errs.add("watcher.videoExtensions: %v", err)
+        }
+    }
+
+    errs.notNegative([]setting{
+        {"watcher.maxRetries", float64(c.Watcher.MaxRetries)},
+        {"watcher.filter.minSize", float64(c.Watcher.Filter.MinSize)},
+        {"watcher.filter.maxSize", float64(c.Watcher.Filter.MaxSize)},
+        {"watcher.hooks.preUpload.timeout", float64(c.Watcher.Hooks.PreUpload.Timeout)},
+        {"watcher.hooks.postSuccess.timeout", float64(c.Watcher.Hooks.PostSuccess.Timeout)},
+        {"watcher.hooks.postFailure.timeout", float64(c.Watcher.Hooks.PostFailure.Timeout)},
+        {"watcher.validation.minDuration", c.Watcher.Validation.MinDuration},
+        {"watcher.validation.maxDuration", c.Watcher.Validation.MaxDuration},
+        {"watcher.validation.timeout", float64(c.Watcher.Validation.Timeout)},
+    })
+
+    if _, err := c.Watcher.ParseUploadWindows(); err != nil {
+        errs.add("watcher.uploadWindows: %w", err)
+    }
+
+    for _, list := range []struct {
+        name     string
+        patterns []string
+    }{
+        {"watcher.filter.include", c.Watcher.Filter.Include},
+        {"watcher.filter.exclude", c.Watcher.Filter.Exclude},
+    } {
+        for _, pattern := range list.patterns {
+            if _, err := filepath.Match(pattern, ""); err != nil {
+                errs.add("%s: invalid pattern %q: %v", list.name, pattern, err)
+            }
+        }
+    }
+    if f := c.Watcher.Filter; f.MaxSize > 0 && f.MaxSize < f.MinSize {
+        errs.add("watcher.filter.maxSize must not be smaller than minSize")
+    }
+
+    switch c.Watcher.Hooks.PreUpload.OnError {
+    case "fail", "skip", "ignore":
+    default:
+        errs.add("watcher.hooks.preUpload.onError: invalid value %q (must be fail, skip or ignore)", c.Watcher.Hooks.PreUpload.OnError)
+    }
+
+    return errs
+}
+
+// validateWatching checks the watch folder and how it is watched
+func (c *Config) validateWatching() []error {
+    var errs errorList
+
     if c.Watcher.WatchPath == "" {
-        return fmt.Errorf("watcher.watchPath is required")
+        errs.add("watcher.watchPath is required")
+    }
+
+    errs.notNegative([]setting{
+        {"watcher.settleTime", float64(c.Watcher.SettleTime)},
+        {"watcher.shutdownTimeout", float64(c.Watcher.ShutdownTimeout)},
+        {"watcher.pollInterval", float64(c.Watcher.PollInterval)},
+        {"watcher.readiness.stableChecks", float64(c.Watcher.Readiness.StableChecks)},
+        {"watcher.readiness.minAge", float64(c.Watcher.Readiness.MinAge)},
+    })
+
+    // A marker that is itself a video would be uploaded
+    if suffix := c.Watcher.Readiness.MarkerSuffix; suffix != "" {
+        for _, ext := range c.Watcher.VideoExtensions {
+            if strings.EqualFold(filepath.Ext(suffix), ext) {
+                errs.add("watcher.readiness.markerSuffix: %q ends in the video extension %s", suffix, ext)
+            }
+        }
+    }
+
+    switch c.Watcher.Backend {
+    case "fsnotify", "poll", "auto":
+    default:
+        errs.add("watcher.backend: invalid value %q (must be fsnotify, poll or auto)", c.Watcher.Backend)
+    }
+
+    // Moving a finished file into the watch folder would upload it again
+    if c.Watcher.WatchPath != "" {
+        for _, p := range []struct{ name, path string }{
+            {"watcher.donePath", c.Watcher.DonePath},
+            {"watcher.failedPath", c.Watcher.FailedPath},
+        } {
+            if p.path != "" && IsWithin(p.path, c.Watcher.WatchPath) {
+                errs.add("%s %s must not be inside watcher.watchPath", p.name, p.path)
+            }
+        }
     }
 
-    // Create directories if they don't exist
-    for _, path := range []string{c.Watcher.WatchPath, c.Watcher.DonePath, c.Watcher.FailedPath} {
-        if path != "" {
-            if err := os.MkdirAll(path, 0755); err != nil {
-                return fmt.Errorf("creating directory %s: %w", path, err)
+    return errs
+}
+
+// validateNotifications checks the webhook and email settings
+func (c *Config) validateNotifications() []error {
+    var errs errorList
+
+    for i, wh := range c.Notifications.Webhooks {
+        name := fmt.Sprintf("notifications.webhooks[%d]", i)
+        if wh.URL == "" {
+            errs.add("%s.url is required", name)
+        } else if err := validateURL(wh.URL); err != nil {
+            errs.add("%s.url: %v", name, err)
+        }
+        if wh.Template != "" {
+            // The functions the webhook provides to its template
+            funcs := template.FuncMap{"json": func(interface{}) (string, error) { return "", nil }}
+            if _, err := template.New("webhook").Funcs(funcs).Parse(wh.Template); err != nil {
+                errs.add("%s.template: invalid template: %w", name, err)
             }
         }
+        if wh.MaxRetries != nil && *wh.MaxRetries < 0 {
+            errs.add("%s.maxRetries must not be negative", name)
+        }
+        errs.notNegative([]setting{
+            {name + ".timeout", float64(wh.Timeout)},
+        })
+    }
+
+    // Email is enabled by setting a host
+    if email := c.Notifications.Email; email.Host != "" {
+        if email.From == "" {
+            errs.add("notifications.email.from is required")
+        }
+        if len(email.To) == 0 {
+            errs.add("notifications.email.to is required")
+        }
+        switch email.TLS {
+        case "starttls", "tls", "none":
+        default:
+            errs.add("notifications.email.tls: invalid value %q (must be starttls, tls or none)", email.TLS)
+        }
+        if _, err := time.Parse("15:04", email.DigestTime); err != nil {
+            errs.add("notifications.email.digestTime: invalid time %q (must be HH:MM)", email.DigestTime)
+        }
+    }
+
+    return errs
+}
+
+// folder is a configured folder and the setting it comes from
+type folder struct {
+    name, path string
+}
+
+// PrepareFolders creates the watch, done and failed folders if they are
+// missing and checks that they and the log file's folder are writable. The
+// watch folder is checked without creating a file in it, which the watcher
+// would see.
+func (c *Config) PrepareFolders() error {
+    errs := prepareFolders([]folder{
+        {"watcher.watchPath", c.Watcher.WatchPath},
+        {"watcher.donePath", c.Watcher.DonePath},
+        {"watcher.failedPath", c.Watcher.FailedPath},
+    }, c.Watcher.WatchPath)
+    if c.Logging.LogFile != "" {
+        if err := checkWritable(filepath.Dir(c.Logging.LogFile)); err != nil {
+            errs = append(errs, fmt.Errorf("logging.logFile: %v", err))
+        }
     }
+    return errorsOrNil(errs)
+}
+
+// PrepareDestinations creates the done and failed folders if they are
+// missing and checks that they are writable, for files uploaded from
+// outside the watch folder
+func (c *Config) PrepareDestinations() error {
+    return errorsOrNil(prepareFolders([]folder{
+        {"watcher.donePath", c.Watcher.DonePath},
+        {"watcher.failedPath", c.Watcher.FailedPath},
+    }, ""))
+}
 
+// prepareFolders creates and checks folders. The watch folder is checked
+// with canWrite, the others with a temporary file.
+func prepareFolders(folders []folder, watchPath string) []error {
+    var errs errorList
+    for _, f := range folders {
+        if f.path == "" {
+            continue
+        }
+        if err := os.MkdirAll(f.path, 0755); err != nil {
+            errs.add("%s: creating directory %s: %w", f.name, f.path, err)
+            continue
+        }
+        check := checkWritable
+        if f.path == watchPath {
+            check = canWrite
+        }
+        if err := check(f.path); err != nil {
+            errs.add("%s: %v", f.name, err)
+        }
+    }
+    return errs
+}
+
+func validateURL(raw string) error {
+    u, err := url.Parse(raw)
+    if err != nil {
+        return err
+    }
+    if u.Scheme != "http" && u.Scheme != "https" {
+        return fmt.Errorf("%q must start with http:// or https://", raw)
+    }
+    if u.Host == "" {
+        return fmt.Errorf("%q has no host name", raw)
+    }
+    return nil
+}
+
+func validateExtension(ext string) error {
+    if len(ext) < 2 || ext[0] != '.' {
+        return fmt.Errorf("%q must start with a dot, e.g. \".mp4\"", ext)
+    }
+    if strings.ContainsAny(ext[1:], `./\*?[] `) {
+        return fmt.Errorf("%q is not a plain file extension", ext)
+    }
     return nil
 }
 
+// IsWithin reports whether path is dir or inside it
+func IsWithin(path, dir string) bool {
+    rel, err := filepath.Rel(dir, path)
+    if err != nil {
+        return false
+    }
+    return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// checkWritable creates and removes a temporary file in dir
+func checkWritable(dir string) error {
+    f, err := os.CreateTemp(dir, ".peertube-monitor-*")
+    if err != nil {
+        return fmt.Errorf("%s is not writable: %w", dir, err)
+    }
+    name := f.Name()
+    f.Close()
+    return os.Remove(name)
+}
+
 // ResolveMetadata resolves category, licence, and privacy from string or int values
-func (c *Config) ResolveMetadata(categories, licences, privacies map[string]string) error {
+func (c *Config) ResolveMetadata(categories, licences, privacies, languages map[string]string) error {
     var err error
 
     // Resolve category
@@ -166,9 +678,37 @@ func (c *Config) ResolveMetadata(categories, licences, privacies map[string]stri
         return err
     }
 
+    // Resolve language
+    c.PeerTube.Defaults.Language, err = ResolveLanguage(c.PeerTube.Defaults.LanguageRaw, languages)
+    if err != nil {
+        return err
+    }
+    c.PeerTube.Defaults.Languages = languages
+
     return nil
 }
 
+// ResolveLanguage returns the language code for a code or a language name.
+// The language is optional, so an empty value stays empty.
+func ResolveLanguage(value string, languages map[string]string) (string, error) {
+    if value == "" {
+        return "", nil
+    }
+
+    for code := range languages {
+        if equalFold(code, value) {
+            return code, nil
+        }
+    }
+    for code, name := range languages {
+        if equalFold(name, value) {
+            return code, nil
+        }
+    }
+
+    return "", fmt.Errorf("language: unknown value %q. Available options: %s", value, formatMapping(languages))
+}
+
 func resolveField(fieldName string, raw json.RawMessage, mapping map[string]string) (int, error) {
     // Try parsing as integer first
     var intVal int
@@ -0,0 +1,120 @@
+package config
+
+import (
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// validConfig returns a configuration that passes Validate, with the
+// defaults Load would set
+func validConfig() *Config {
+    cfg := &Config{}
+    cfg.PeerTube.URL = "https://peertube.example.com"
+    cfg.Watcher.WatchPath = filepath.FromSlash("/srv/upload")
+    cfg.Watcher.VideoExtensions = []string{".mp4"}
+    cfg.Watcher.Backend = "auto"
+    cfg.Watcher.Hooks.PreUpload.OnError = "fail"
+    return cfg
+}
+
+func TestValidate(t *testing.T) {
+    tests := []struct {
+        name   string
+        modify func(*Config)
+        err    string // "" = valid
+    }{
+        {"valid", func(c *Config) {}, ""},
+        {"no watch path", func(c *Config) { c.Watcher.WatchPath = "" }, "watcher.watchPath is required"},
+        {"channel and id", func(c *Config) { c.PeerTube.Defaults.Channel, c.PeerTube.Defaults.ChannelID = "news", 3 }, "either channel or channelId"},
+        {"url without host", func(c *Config) { c.PeerTube.URL = "https://" }, "no host name"},
+        {"negative timeout", func(c *Config) { c.PeerTube.Timeouts.Idle = -1 }, "peertube.timeouts.idle must not be negative"},
+        {"extension without dot", func(c *Config) { c.Watcher.VideoExtensions = []string{"mp4"} }, "must start with a dot"},
+        {"bad pattern", func(c *Config) { c.Watcher.Filter.Exclude = []string{"[a"} }, "watcher.filter.exclude"},
+        {"max below min size", func(c *Config) { c.Watcher.Filter.MinSize, c.Watcher.Filter.MaxSize = 10, 5 }, "maxSize must not be smaller"},
+        {"bad upload window", func(c *Config) { c.Watcher.UploadWindows = []string{"22:00"} }, "watcher.uploadWindows"},
+        {"marker is a video", func(c *Config) { c.Watcher.Readiness.MarkerSuffix = ".ready.mp4" }, "markerSuffix"},
+        {"done inside watch", func(c *Config) { c.Watcher.DonePath = filepath.Join(c.Watcher.WatchPath, "done") }, "must not be inside watcher.watchPath"},
+        {"keep all backups", func(c *Config) { c.Logging.MaxBackups = -1 }, ""},
+        {"bad backups", func(c *Config) { c.Logging.MaxBackups = -2 }, "logging.maxBackups"},
+        {"bad webhook url", func(c *Config) { c.Notifications.Webhooks = []WebhookConfig{{URL: "hooks.example.com"}} }, "notifications.webhooks[0].url"},
+        {"email disabled", func(c *Config) { c.Notifications.Email = EmailConfig{TLS: "ssl"} }, ""},
+        {"email", func(c *Config) {
+            c.Notifications.Email = EmailConfig{Host: "smtp.example.com", From: "a@example.com", To: []string{"b@example.com"}, TLS: "tls", DigestTime: "23:30"}
+        }, ""},
+    }
+    for _, tt := range tests {
+        cfg := validConfig()
+        tt.modify(cfg)
+        err := cfg.Validate()
+        switch {
+        case tt.err == "" && err != nil:
+            t.Errorf("%s: %v", tt.name, err)
+        case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+            t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+        }
+    }
+}
+
+func TestValidateUploadSkipsWatching(t *testing.T) {
+    cfg := validConfig()
+    cfg.Watcher.WatchPath = ""
+    cfg.Watcher.Backend = ""
+    if err := cfg.ValidateUpload(); err != nil {
+        t.Error(err)
+    }
+    if err := cfg.Validate(); err == nil {
+        t.Error("Validate accepted a config without a watch folder")
+    }
+}
+
+func TestIsWithin(t *testing.T) {
+    root := filepath.FromSlash("/srv/upload")
+    tests := []struct {
+        path string
+        want bool
+    }{
+        {"/srv/upload", true},
+        {"/srv/upload/", true},
+        {"/srv/upload/done", true},
+        {"/srv/upload/a/b/../c", true},
+        {"/srv/upload/..done", true},
+        {"/srv/uploaded", false},
+        {"/srv", false},
+        {"/srv/done", false},
+        {"/srv/upload/../done", false},
+    }
+    for _, tt := range tests {
+        if got := IsWithin(filepath.FromSlash(tt.path), root); got != tt.want {
+            t.Errorf("IsWithin(%q, %q) = %v, want %v", tt.path, root, got, tt.want)
+        }
+    }
+}
+
+func TestResolveLanguage(t *testing.T) {
+    languages := map[string]string{"da": "Danish", "en": "English", "pt-br": "Portuguese (Brazil)"}
+    tests := []struct {
+        value, want string
+        err         bool
+    }{
+        {"", "", false},
+        {"da", "da", false},
+        {"DA", "da", false},
+        {"danish", "da", false},
+        {"Portuguese (Brazil)", "pt-br", false},
+        {"PT-BR", "pt-br", false},
+        {"Klingon", "", true},
+    }
+    for _, tt := range tests {
+        got, err := ResolveLanguage(tt.value, languages)
+        if (err != nil) != tt.err || got != tt.want {
+            t.Errorf("ResolveLanguage(%q) = %q, %v; want %q (error %v)", tt.value, got, err, tt.want, tt.err)
+        }
+    }
+
+    // The error lists what the server knows
+    _, err := ResolveLanguage("Klingon", languages)
+    if err == nil || !strings.Contains(err.Error(), `da="Danish"`) {
+        t.Errorf("err = %v, want the available languages", err)
+    }
+}
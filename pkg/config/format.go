@@ -0,0 +1,106 @@
+package config
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+
+    "github.com/BurntSushi/toml"
+    "gopkg.in/yaml.v3"
+)
+
+// envPattern matches ${VAR} and ${VAR:-default}
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// decodeFile parses a config file into generic values. The format is chosen
+// by extension: .yaml/.yml, .toml, anything else is JSON.
+func decodeFile(path string, data []byte) (interface{}, error) {
+    var raw interface{}
+
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".yaml", ".yml":
+        if err := yaml.Unmarshal(data, &raw); err != nil {
+            return nil, err
+        }
+    case ".toml":
+        var table map[string]interface{}
+        if _, err := toml.Decode(string(data), &table); err != nil {
+            return nil, err
+        }
+        raw = table
+    default:
+        // Keep numbers exact until they are decoded into the config
+        decoder := json.NewDecoder(bytes.NewReader(data))
+        decoder.UseNumber()
+        if err := decoder.Decode(&raw); err != nil {
+            return nil, err
+        }
+    }
+
+    // An empty YAML document decodes to nil
+    if raw == nil {
+        raw = map[string]interface{}{}
+    }
+    if _, ok := raw.(map[string]interface{}); !ok {
+        return nil, fmt.Errorf("top level must be an object")
+    }
+    return raw, nil
+}
+
+// interpolate expands ${VAR} and ${VAR:-default} in every string value.
+// The default is used when VAR is unset or empty; an unset VAR without a
+// default expands to "".
+func interpolate(value interface{}) interface{} {
+    switch v := value.(type) {
+    case string:
+        return envPattern.ReplaceAllStringFunc(v, func(match string) string {
+            parts := envPattern.FindStringSubmatch(match)
+            if env := os.Getenv(parts[1]); env != "" || parts[2] == "" {
+                return env
+            }
+            return parts[3]
+        })
+    case map[string]interface{}:
+        for key, item := range v {
+            v[key] = interpolate(item)
+        }
+    case []interface{}:
+        for i, item := range v {
+            v[i] = interpolate(item)
+        }
+    case []map[string]interface{}:
+        // TOML arrays of tables
+        for _, item := range v {
+            interpolate(item)
+        }
+    }
+    return value
+}
+
+// WriteFile writes generic values as a config file, in the format chosen by
+// the file extension like decodeFile
+func WriteFile(path string, raw map[string]interface{}) error {
+    var data []byte
+    var err error
+
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".yaml", ".yml":
+        data, err = yaml.Marshal(raw)
+    case ".toml":
+        var buf bytes.Buffer
+        err = toml.NewEncoder(&buf).Encode(raw)
+        data = buf.Bytes()
+    default:
+        data, err = json.MarshalIndent(raw, "", "  ")
+        data = append(data, '\n')
+    }
+    if err != nil {
+        return fmt.Errorf("encoding config: %w", err)
+    }
+
+    return os.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,227 @@
+package config
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+// writeConfig writes a config file into a temporary folder
+func writeConfig(t *testing.T, name, content string) string {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), name)
+    if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+func TestInterpolate(t *testing.T) {
+    t.Setenv("PTM_HOST", "peertube.example.com")
+    t.Setenv("PTM_EMPTY", "")
+
+    tests := []struct {
+        in, want string
+    }{
+        {"plain", "plain"},
+        {"https://${PTM_HOST}/", "https://peertube.example.com/"},
+        {"${PTM_HOST:-localhost}", "peertube.example.com"},
+        {"${PTM_UNSET:-localhost}", "localhost"},
+        {"${PTM_EMPTY:-localhost}", "localhost"},
+        {"${PTM_UNSET}", ""},
+        {"${PTM_UNSET:-}", ""},
+        {"${PTM_HOST}:${PTM_UNSET:-8080}", "peertube.example.com:8080"},
+        {"$PTM_HOST", "$PTM_HOST"},
+        {"${1BAD}", "${1BAD}"},
+    }
+    for _, tt := range tests {
+        if got := interpolate(tt.in); got != tt.want {
+            t.Errorf("interpolate(%q) = %q, want %q", tt.in, got, tt.want)
+        }
+    }
+}
+
+func TestInterpolateNested(t *testing.T) {
+    t.Setenv("PTM_TAG", "news")
+
+    raw := map[string]interface{}{
+        "tags":     []interface{}{"${PTM_TAG}", "fixed"},
+        "webhooks": []map[string]interface{}{{"url": "http://${PTM_TAG}"}},
+        "count":    3,
+    }
+    want := map[string]interface{}{
+        "tags":     []interface{}{"news", "fixed"},
+        "webhooks": []map[string]interface{}{{"url": "http://news"}},
+        "count":    3,
+    }
+    if got := interpolate(raw); !reflect.DeepEqual(got, want) {
+        t.Errorf("interpolate = %v, want %v", got, want)
+    }
+}
+
+func TestLoadFormats(t *testing.T) {
+    t.Setenv("PTM_WATCH", "/srv/upload")
+
+    files := map[string]string{
+        "config.json": `{
+  "peertube": {"url": "https://peertube.example.com", "defaults": {"channel": "news", "tags": ["a", "b"]}},
+  "watcher": {"watchPath": "${PTM_WATCH}", "settleTime": 10, "validation": {"maxDuration": 1.5}},
+  "notifications": {"webhooks": [{"url": "https://hooks.example.com/a"}, {"url": "https://hooks.example.com/b", "timeout": 3}]}
+}`,
+        "config.yaml": `
+peertube:
+  url: https://peertube.example.com
+  defaults:
+    channel: news
+    tags: [a, b]
+watcher:
+  watchPath: ${PTM_WATCH}
+  settleTime: 10
+  validation:
+    maxDuration: 1.5
+notifications:
+  webhooks:
+    - url: https://hooks.example.com/a
+    - url: https://hooks.example.com/b
+      timeout: 3
+`,
+        "config.toml": `
+[peertube]
+url = "https://peertube.example.com"
+
+[peertube.defaults]
+channel = "news"
+tags = ["a", "b"]
+
+[watcher]
+watchPath = "${PTM_WATCH}"
+settleTime = 10
+
+[watcher.validation]
+maxDuration = 1.5
+
+[[notifications.webhooks]]
+url = "https://hooks.example.com/a"
+
+[[notifications.webhooks]]
+url = "https://hooks.example.com/b"
+timeout = 3
+`,
+    }
+
+    for name, content := range files {
+        cfg, err := Load(writeConfig(t, name, content))
+        if err != nil {
+            t.Errorf("%s: %v", name, err)
+            continue
+        }
+        if err := cfg.Validate(); err != nil {
+            t.Errorf("%s: %v", name, err)
+        }
+
+        if cfg.PeerTube.URL != "https://peertube.example.com" || cfg.PeerTube.Defaults.Channel != "news" {
+            t.Errorf("%s: peertube = %+v", name, cfg.PeerTube)
+        }
+        if !reflect.DeepEqual(cfg.PeerTube.Defaults.Tags, []string{"a", "b"}) {
+            t.Errorf("%s: tags = %q", name, cfg.PeerTube.Defaults.Tags)
+        }
+        if cfg.Watcher.WatchPath != mustAbs(t, "/srv/upload") {
+            t.Errorf("%s: watchPath = %q", name, cfg.Watcher.WatchPath)
+        }
+        if cfg.Watcher.SettleTime != 10 || cfg.Watcher.Validation.MaxDuration != 1.5 {
+            t.Errorf("%s: settleTime = %d, maxDuration = %v", name, cfg.Watcher.SettleTime, cfg.Watcher.Validation.MaxDuration)
+        }
+
+        webhooks := cfg.Notifications.Webhooks
+        if len(webhooks) != 2 || webhooks[0].URL != "https://hooks.example.com/a" || webhooks[1].Timeout != 3 {
+            t.Errorf("%s: webhooks = %+v", name, webhooks)
+            continue
+        }
+        // Defaults apply to every format alike
+        if webhooks[0].Timeout != 10 || cfg.Watcher.MaxRetries != 3 {
+            t.Errorf("%s: defaults not applied: webhook timeout %d, maxRetries %d", name, webhooks[0].Timeout, cfg.Watcher.MaxRetries)
+        }
+    }
+}
+
+func TestLoadEmptyYAML(t *testing.T) {
+    cfg, err := Load(writeConfig(t, "config.yml", "# nothing yet\n"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if cfg.Watcher.SettleTime != 5 {
+        t.Errorf("settleTime = %d, want the default 5", cfg.Watcher.SettleTime)
+    }
+}
+
+func TestLoadSyntaxErrors(t *testing.T) {
+    for name, content := range map[string]string{
+        "config.json": `{"watcher": `,
+        "config.yaml": "watcher:\n  watchPath: [unclosed\n",
+        "config.toml": "[watcher\n",
+        "list.yaml":   "- a\n- b\n",
+    } {
+        if _, err := Load(writeConfig(t, name, content)); err == nil {
+            t.Errorf("%s: expected an error", name)
+        }
+    }
+}
+
+func TestWriteFileRoundTrip(t *testing.T) {
+    raw := map[string]interface{}{
+        "peertube": map[string]interface{}{"url": "https://peertube.example.com"},
+        "watcher":  map[string]interface{}{"watchPath": "/srv/upload", "settleTime": 7},
+    }
+
+    for _, name := range []string{"config.json", "config.yaml", "config.toml"} {
+        path := filepath.Join(t.TempDir(), name)
+        if err := WriteFile(path, raw); err != nil {
+            t.Errorf("%s: %v", name, err)
+            continue
+        }
+        cfg, err := Load(path)
+        if err != nil {
+            t.Errorf("%s: %v", name, err)
+            continue
+        }
+        if cfg.PeerTube.URL != "https://peertube.example.com" || cfg.Watcher.SettleTime != 7 {
+            t.Errorf("%s: read back url %q, settleTime %d", name, cfg.PeerTube.URL, cfg.Watcher.SettleTime)
+        }
+    }
+}
+
+func mustAbs(t *testing.T, path string) string {
+    t.Helper()
+    abs, err := filepath.Abs(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return abs
+}
+
+func TestLoadWebhookRetries(t *testing.T) {
+    cfg, err := Load(writeConfig(t, "config.yaml", `
+notifications:
+  webhooks:
+    - url: https://hooks.example.com/default
+    - url: https://hooks.example.com/none
+      maxRetries: 0
+    - url: https://hooks.example.com/two
+      maxRetries: 2
+`))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var got []int
+    for _, wh := range cfg.Notifications.Webhooks {
+        if wh.MaxRetries == nil {
+            t.Fatalf("%s: maxRetries not set", wh.URL)
+        }
+        got = append(got, *wh.MaxRetries)
+    }
+    if !reflect.DeepEqual(got, []int{5, 0, 2}) {
+        t.Errorf("maxRetries = %v, want [5 0 2]", got)
+    }
+}
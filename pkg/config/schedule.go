@@ -0,0 +1,96 @@
+package config
+
+import (
+    "fmt"
+    "strings"
+    "time"
+)
+
+// TimeWindow is a daily time range in local time. A window whose end is
+// before its start wraps past midnight, e.g. 22:00-06:00.
+type TimeWindow struct {
+    Start time.Duration // offset from midnight
+    End   time.Duration
+}
+
+// ParseTimeWindow parses "HH:MM-HH:MM"
+func ParseTimeWindow(s string) (TimeWindow, error) {
+    parts := strings.Split(s, "-")
+    if len(parts) != 2 {
+        return TimeWindow{}, fmt.Errorf("invalid time window %q (must be HH:MM-HH:MM)", s)
+    }
+
+    var w TimeWindow
+    for i, part := range parts {
+        t, err := time.Parse("15:04", strings.TrimSpace(part))
+        if err != nil {
+            return TimeWindow{}, fmt.Errorf("invalid time window %q (must be HH:MM-HH:MM)", s)
+        }
+        offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+        if i == 0 {
+            w.Start = offset
+        } else {
+            w.End = offset
+        }
+    }
+
+    if w.Start == w.End {
+        return TimeWindow{}, fmt.Errorf("invalid time window %q (start and end are equal)", s)
+    }
+    return w, nil
+}
+
+// Contains reports whether t falls inside the window
+func (w TimeWindow) Contains(t time.Time) bool {
+    offset := sinceMidnight(t)
+    if w.Start < w.End {
+        return offset >= w.Start && offset < w.End
+    }
+    return offset >= w.Start || offset < w.End
+}
+
+// UntilOpen returns how long until the window next opens (0 if open now)
+func (w TimeWindow) UntilOpen(t time.Time) time.Duration {
+    if w.Contains(t) {
+        return 0
+    }
+    wait := w.Start - sinceMidnight(t)
+    if wait < 0 {
+        wait += 24 * time.Hour
+    }
+    return wait
+}
+
+// UntilNextWindow returns how long until any of the windows is open. It is 0
+// when a window is open now or no windows are configured.
+func UntilNextWindow(windows []TimeWindow, t time.Time) time.Duration {
+    var next time.Duration
+    for i, w := range windows {
+        wait := w.UntilOpen(t)
+        if wait == 0 {
+            return 0
+        }
+        if i == 0 || wait < next {
+            next = wait
+        }
+    }
+    return next
+}
+
+// ParseUploadWindows parses the configured upload windows
+func (c *WatcherConfig) ParseUploadWindows() ([]TimeWindow, error) {
+    var windows []TimeWindow
+    for _, s := range c.UploadWindows {
+        w, err := ParseTimeWindow(s)
+        if err != nil {
+            return nil, err
+        }
+        windows = append(windows, w)
+    }
+    return windows, nil
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+    return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+        time.Duration(t.Second())*time.Second
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+    "testing"
+    "time"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+    tests := []struct {
+        in         string
+        start, end time.Duration
+        err        bool
+    }{
+        {"22:00-06:00", 22 * time.Hour, 6 * time.Hour, false},
+        {"09:30-17:00", 9*time.Hour + 30*time.Minute, 17 * time.Hour, false},
+        {" 00:00 - 23:59 ", 0, 23*time.Hour + 59*time.Minute, false},
+        {"22:00", 0, 0, true},
+        {"22:00-06:00-07:00", 0, 0, true},
+        {"10pm-6am", 0, 0, true},
+        {"24:00-06:00", 0, 0, true},
+        {"08:00-08:00", 0, 0, true},
+        {"", 0, 0, true},
+    }
+    for _, tt := range tests {
+        w, err := ParseTimeWindow(tt.in)
+        if tt.err {
+            if err == nil {
+                t.Errorf("ParseTimeWindow(%q) = %+v, want an error", tt.in, w)
+            }
+            continue
+        }
+        if err != nil || w.Start != tt.start || w.End != tt.end {
+            t.Errorf("ParseTimeWindow(%q) = %+v, %v", tt.in, w, err)
+        }
+    }
+}
+
+func TestTimeWindowContains(t *testing.T) {
+    day := TimeWindow{Start: 9 * time.Hour, End: 17 * time.Hour}
+    night := TimeWindow{Start: 22 * time.Hour, End: 6 * time.Hour}
+
+    tests := []struct {
+        w    TimeWindow
+        at   string
+        want bool
+    }{
+        {day, "09:00", true},
+        {day, "16:59", true},
+        {day, "17:00", false},
+        {day, "08:59", false},
+        {night, "22:00", true},
+        {night, "23:59", true},
+        {night, "00:00", true},
+        {night, "05:59", true},
+        {night, "06:00", false},
+        {night, "12:00", false},
+    }
+    for _, tt := range tests {
+        if got := tt.w.Contains(clock(t, tt.at)); got != tt.want {
+            t.Errorf("%+v.Contains(%s) = %v, want %v", tt.w, tt.at, got, tt.want)
+        }
+    }
+}
+
+func TestUntilNextWindow(t *testing.T) {
+    windows := []TimeWindow{
+        {Start: 22 * time.Hour, End: 6 * time.Hour},
+        {Start: 12 * time.Hour, End: 13 * time.Hour},
+    }
+
+    tests := []struct {
+        at   string
+        want time.Duration
+    }{
+        {"23:00", 0},
+        {"12:30", 0},
+        {"06:00", 6 * time.Hour},
+        {"11:45", 15 * time.Minute},
+        {"13:00", 9 * time.Hour},
+    }
+    for _, tt := range tests {
+        if got := UntilNextWindow(windows, clock(t, tt.at)); got != tt.want {
+            t.Errorf("UntilNextWindow at %s = %v, want %v", tt.at, got, tt.want)
+        }
+    }
+
+    if got := UntilNextWindow(nil, clock(t, "03:00")); got != 0 {
+        t.Errorf("no windows: %v, want 0", got)
+    }
+}
+
+// clock returns today at the local time "HH:MM"
+func clock(t *testing.T, hhmm string) time.Time {
+    t.Helper()
+    at, err := time.Parse("15:04", hhmm)
+    if err != nil {
+        t.Fatal(err)
+    }
+    now := time.Now()
+    return time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, time.Local)
+}
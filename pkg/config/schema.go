@@ -0,0 +1,287 @@
+package config
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Errors collects several configuration problems so they can be reported
+// together
+type Errors []error
+
+func (e Errors) Error() string {
+    if len(e) == 1 {
+        return e[0].Error()
+    }
+    msgs := make([]string, len(e))
+    for i, err := range e {
+        msgs[i] = "\n  - " + err.Error()
+    }
+    return fmt.Sprintf("%d problems:%s", len(e), strings.Join(msgs, ""))
+}
+
+func (e Errors) Unwrap() []error {
+    return e
+}
+
+// errorsOrNil returns errs as an error, or nil if there are none
+func errorsOrNil(errs []error) error {
+    if len(errs) == 0 {
+        return nil
+    }
+    return Errors(errs)
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// checkFields reports keys in raw that don't match a field of typ, with a
+// suggestion for the closest known name. Keys match case-insensitively,
+// like encoding/json.
+func checkFields(raw interface{}, typ reflect.Type, prefix string) []error {
+    for typ.Kind() == reflect.Pointer {
+        typ = typ.Elem()
+    }
+
+    switch typ.Kind() {
+    case reflect.Struct:
+        if typ == rawMessageType {
+            return nil
+        }
+        obj, ok := raw.(map[string]interface{})
+        if !ok {
+            // Wrong types are reported when decoding
+            return nil
+        }
+
+        fields := jsonFields(typ)
+        var names []string
+        for name := range fields {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+
+        var keys []string
+        for key := range obj {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+
+        var errs []error
+        for _, key := range keys {
+            field, ok := lookupField(fields, key)
+            if !ok {
+                msg := fmt.Sprintf("unknown field %q", prefix+key)
+                if suggestion := suggest(key, names); suggestion != "" {
+                    msg += fmt.Sprintf(" (did you mean %q?)", suggestion)
+                }
+                errs = append(errs, fmt.Errorf("%s", msg))
+                continue
+            }
+            errs = append(errs, checkFields(obj[key], field.Type, prefix+key+".")...)
+        }
+        return errs
+
+    case reflect.Slice:
+        if typ == rawMessageType {
+            return nil
+        }
+        var errs []error
+        prefix = strings.TrimSuffix(prefix, ".")
+        switch items := raw.(type) {
+        case []interface{}:
+            for i, item := range items {
+                errs = append(errs, checkFields(item, typ.Elem(), fmt.Sprintf("%s[%d].", prefix, i))...)
+            }
+        case []map[string]interface{}:
+            for i, item := range items {
+                errs = append(errs, checkFields(item, typ.Elem(), fmt.Sprintf("%s[%d].", prefix, i))...)
+            }
+        }
+        return errs
+    }
+
+    return nil
+}
+
+// decodeConfig decodes raw into cfg. json.Unmarshal only reports the first
+// value of the wrong type, so each one is reported, dropped from raw and the
+// decoding repeated until the rest decodes cleanly.
+func decodeConfig(raw map[string]interface{}, cfg *Config) ([]error, error) {
+    var errs []error
+    for {
+        data, err := json.Marshal(raw)
+        if err != nil {
+            return errs, err
+        }
+
+        loadErrors := cfg.loadErrors
+        *cfg = Config{loadErrors: loadErrors}
+        err = json.Unmarshal(data, cfg)
+
+        var typeErr *json.UnmarshalTypeError
+        if !errors.As(err, &typeErr) {
+            return errs, err
+        }
+        path := strings.Split(typeErr.Field, ".")
+        errs = append(errs, fmt.Errorf("%s must be %s, not %s", fieldName(path), describeType(typeErr.Type), typeErr.Value))
+        if typeErr.Field == "" || !dropField(raw, path) {
+            return errs, nil
+        }
+    }
+}
+
+// fieldName writes a path from a decoding error the way checkFields does,
+// with array indexes in brackets
+func fieldName(path []string) string {
+    var name strings.Builder
+    for i, part := range path {
+        if _, err := strconv.Atoi(part); err == nil {
+            name.WriteString("[" + part + "]")
+            continue
+        }
+        if i > 0 {
+            name.WriteString(".")
+        }
+        name.WriteString(part)
+    }
+    return name.String()
+}
+
+// dropField removes the value at path from raw and reports whether it was
+// there. An array without an index in the path has the value removed from
+// every element.
+func dropField(raw interface{}, path []string) bool {
+    var items []interface{}
+    switch v := raw.(type) {
+    case map[string]interface{}:
+        for key, item := range v {
+            if !strings.EqualFold(key, path[0]) {
+                continue
+            }
+            if len(path) == 1 {
+                delete(v, key)
+                return true
+            }
+            return dropField(item, path[1:])
+        }
+        return false
+    case []interface{}:
+        items = v
+    case []map[string]interface{}:
+        for _, item := range v {
+            items = append(items, item)
+        }
+    default:
+        return false
+    }
+
+    if i, err := strconv.Atoi(path[0]); err == nil {
+        if i < 0 || i >= len(items) {
+            return false
+        }
+        if len(path) == 1 {
+            // Leave a null in place so the other indexes still match
+            if list, ok := raw.([]interface{}); ok {
+                list[i] = nil
+                return true
+            }
+            return false
+        }
+        return dropField(items[i], path[1:])
+    }
+
+    dropped := false
+    for _, item := range items {
+        dropped = dropField(item, path) || dropped
+    }
+    return dropped
+}
+
+// describeType names the kind of value a setting of type typ takes
+func describeType(typ reflect.Type) string {
+    switch typ.Kind() {
+    case reflect.Bool:
+        return "true or false"
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+        reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return "a whole number"
+    case reflect.Float32, reflect.Float64:
+        return "a number"
+    case reflect.String:
+        return "a string"
+    case reflect.Slice, reflect.Array:
+        return "a list"
+    case reflect.Map, reflect.Struct:
+        return "an object"
+    }
+    return typ.String()
+}
+
+// jsonFields maps the JSON names of typ's fields to the fields
+func jsonFields(typ reflect.Type) map[string]reflect.StructField {
+    fields := make(map[string]reflect.StructField)
+    for i := 0; i < typ.NumField(); i++ {
+        field := typ.Field(i)
+        if !field.IsExported() {
+            continue
+        }
+        name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+        if name == "-" {
+            continue
+        }
+        if name == "" {
+            name = field.Name
+        }
+        fields[name] = field
+    }
+    return fields
+}
+
+func lookupField(fields map[string]reflect.StructField, key string) (reflect.StructField, bool) {
+    if field, ok := fields[key]; ok {
+        return field, true
+    }
+    for name, field := range fields {
+        if strings.EqualFold(name, key) {
+            return field, true
+        }
+    }
+    return reflect.StructField{}, false
+}
+
+// suggest returns the name closest to key, or "" if none is close enough
+// to be a likely typo
+func suggest(key string, names []string) string {
+    best, bestDist := "", len(key)/3+2
+    for _, name := range names {
+        if dist := levenshtein(strings.ToLower(key), strings.ToLower(name)); dist < bestDist {
+            best, bestDist = name, dist
+        }
+    }
+    return best
+}
+
+func levenshtein(a, b string) int {
+    prev := make([]int, len(b)+1)
+    curr := make([]int, len(b)+1)
+    for j := range prev {
+        prev[j] = j
+    }
+    for i := 1; i <= len(a); i++ {
+        curr[0] = i
+        for j := 1; j <= len(b); j++ {
+            cost := 1
+            if a[i-1] == b[j-1] {
+                cost = 0
+            }
+            curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+        }
+        prev, curr = curr, prev
+    }
+    return prev[len(b)]
+}
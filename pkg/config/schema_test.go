@@ -0,0 +1,222 @@
+package config
+
+import (
+    "errors"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestCheckFields(t *testing.T) {
+    tests := []struct {
+        name string
+        raw  map[string]interface{}
+        want []string
+    }{
+        {
+            "known fields in any case",
+            map[string]interface{}{"Watcher": map[string]interface{}{"WATCHPATH": "/srv"}},
+            nil,
+        },
+        {
+            "top level",
+            map[string]interface{}{"loging": map[string]interface{}{}},
+            []string{`unknown field "loging" (did you mean "logging"?)`},
+        },
+        {
+            "nested",
+            map[string]interface{}{"watcher": map[string]interface{}{"readiness": map[string]interface{}{"stableCheck": 2}}},
+            []string{`unknown field "watcher.readiness.stableCheck" (did you mean "stableChecks"?)`},
+        },
+        {
+            "array element",
+            map[string]interface{}{"notifications": map[string]interface{}{"webhooks": []interface{}{
+                map[string]interface{}{"url": "http://a"},
+                map[string]interface{}{"tiemout": 3},
+            }}},
+            []string{`unknown field "notifications.webhooks[1].tiemout" (did you mean "timeout"?)`},
+        },
+        {
+            "TOML array of tables",
+            map[string]interface{}{"notifications": map[string]interface{}{"webhooks": []map[string]interface{}{
+                {"secrte": "x"},
+            }}},
+            []string{`unknown field "notifications.webhooks[0].secrte" (did you mean "secret"?)`},
+        },
+        {
+            "no close name",
+            map[string]interface{}{"peertube": map[string]interface{}{"colour": "blue"}},
+            []string{`unknown field "peertube.colour"`},
+        },
+        {
+            "sorted, wrong types left for decoding",
+            map[string]interface{}{"zzz": 1, "aaa": 1, "watcher": "not an object"},
+            []string{`unknown field "aaa"`, `unknown field "zzz"`},
+        },
+        {
+            "raw metadata values are not checked",
+            map[string]interface{}{"peertube": map[string]interface{}{"defaults": map[string]interface{}{"category": map[string]interface{}{"any": 1}}}},
+            nil,
+        },
+    }
+    for _, tt := range tests {
+        var got []string
+        for _, err := range checkFields(tt.raw, reflect.TypeOf(Config{}), "") {
+            got = append(got, err.Error())
+        }
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestDecodeConfigReportsEveryTypeError(t *testing.T) {
+    raw := map[string]interface{}{
+        "watcher": map[string]interface{}{
+            "settleTime": "soon",
+            "maxRetries": 2.5,
+            "watchPath":  "/srv/upload",
+        },
+        "logging": map[string]interface{}{"verbose": "yes"},
+        "notifications": map[string]interface{}{"webhooks": []interface{}{
+            map[string]interface{}{"url": "http://a", "timeout": 5},
+            map[string]interface{}{"url": "http://b", "timeout": "5"},
+        }},
+    }
+
+    var cfg Config
+    errs, err := decodeConfig(raw, &cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var got []string
+    for _, err := range errs {
+        got = append(got, err.Error())
+    }
+    want := []string{
+        "logging.verbose must be true or false, not string",
+        "notifications.webhooks[1].timeout must be a whole number, not string",
+        "watcher.maxRetries must be a whole number, not number 2.5",
+        "watcher.settleTime must be a whole number, not string",
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("errors:\n got %q\nwant %q", got, want)
+    }
+
+    // The values around the wrong ones are still decoded
+    if cfg.Watcher.WatchPath != "/srv/upload" || len(cfg.Notifications.Webhooks) != 2 || cfg.Notifications.Webhooks[0].Timeout != 5 {
+        t.Errorf("decoded %+v", cfg)
+    }
+}
+
+func TestValidateCollectsAllProblems(t *testing.T) {
+    path := writeConfig(t, "config.yaml", `
+peertube:
+  url: ftp://peertube.example.com
+  defaults:
+    nmae: x
+watcher:
+  watchPath: /srv/upload
+  settleTime: soon
+  backend: inotify
+notifications:
+  webhooks:
+    - template: "{{ json .Name "
+  email:
+    host: smtp.example.com
+    tls: ssl
+    digestTime: 8am
+`)
+    cfg, err := Load(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = cfg.Validate()
+    var errs Errors
+    if !errors.As(err, &errs) {
+        t.Fatalf("err = %v, want Errors", err)
+    }
+
+    want := []string{
+        `unknown field "peertube.defaults.nmae"`,
+        "watcher.settleTime must be a whole number",
+        "peertube.url",
+        "watcher.backend",
+        "notifications.webhooks[0].url is required",
+        "notifications.webhooks[0].template",
+        "notifications.email.from is required",
+        "notifications.email.to is required",
+        "notifications.email.tls",
+        "notifications.email.digestTime",
+    }
+    if len(errs) != len(want) {
+        t.Errorf("got %d problems, want %d:\n%v", len(errs), len(want), err)
+    }
+    for i, prefix := range want {
+        if i < len(errs) && !strings.HasPrefix(errs[i].Error(), prefix) {
+            t.Errorf("problem %d = %q, want it to start with %q", i, errs[i], prefix)
+        }
+    }
+    if !strings.HasPrefix(err.Error(), "10 problems:\n  - ") {
+        t.Errorf("message = %q", err)
+    }
+
+    // The upload command reports the same load and notification problems
+    if err := cfg.ValidateUpload(); err == nil || !strings.Contains(err.Error(), "nmae") || !strings.Contains(err.Error(), "digestTime") {
+        t.Errorf("ValidateUpload = %v", err)
+    }
+}
+
+func TestValidateWebhookTemplateFunctions(t *testing.T) {
+    cfg := validConfig()
+    cfg.Notifications.Webhooks = []WebhookConfig{{URL: "https://hooks.example.com", Template: `{"text": {{ json .Name }}}`}}
+    if err := cfg.Validate(); err != nil {
+        t.Error(err)
+    }
+
+    cfg.Notifications.Webhooks[0].Template = `{"text": {{ yaml .Name }}}`
+    if err := cfg.Validate(); err == nil {
+        t.Error("expected an error for an unknown template function")
+    }
+}
+
+func TestErrorsMessage(t *testing.T) {
+    one := Errors{errors.New("watcher.watchPath is required")}
+    if one.Error() != "watcher.watchPath is required" {
+        t.Errorf("one problem: %q", one.Error())
+    }
+
+    target := errors.New("b")
+    two := Errors{errors.New("a"), target}
+    if two.Error() != "2 problems:\n  - a\n  - b" {
+        t.Errorf("two problems: %q", two.Error())
+    }
+    if !errors.Is(two, target) {
+        t.Error("errors.Is does not find a collected error")
+    }
+
+    if errorsOrNil(nil) != nil {
+        t.Error("errorsOrNil(nil) is not nil")
+    }
+}
+
+func TestSuggest(t *testing.T) {
+    names := []string{"settleTime", "maxRetries", "watchPath", "donePath"}
+    tests := []struct {
+        key, want string
+    }{
+        {"settletime", "settleTime"},
+        {"setleTime", "settleTime"},
+        {"maxRetry", "maxRetries"},
+        {"watch_path", "watchPath"},
+        {"color", ""},
+        {"x", ""},
+    }
+    for _, tt := range tests {
+        if got := suggest(tt.key, names); got != tt.want {
+            t.Errorf("suggest(%q) = %q, want %q", tt.key, got, tt.want)
+        }
+    }
+}
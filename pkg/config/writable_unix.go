@@ -0,0 +1,17 @@
+//go:build !windows
+
+package config
+
+import (
+    "fmt"
+
+    "golang.org/x/sys/unix"
+)
+
+// canWrite reports whether files may be created in dir, without creating one
+func canWrite(dir string) error {
+    if err := unix.Access(dir, unix.W_OK|unix.X_OK); err != nil {
+        return fmt.Errorf("%s is not writable: %w", dir, err)
+    }
+    return nil
+}
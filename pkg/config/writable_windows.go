@@ -0,0 +1,20 @@
+package config
+
+import (
+    "fmt"
+    "os"
+)
+
+// canWrite checks that dir is a folder. Windows has no access(2), and
+// whether its ACLs allow creating files is only known for sure by trying, so
+// a folder that isn't writable shows up when files are moved out of it.
+func canWrite(dir string) error {
+    info, err := os.Stat(dir)
+    if err != nil {
+        return err
+    }
+    if !info.IsDir() {
+        return fmt.Errorf("%s is not a folder", dir)
+    }
+    return nil
+}
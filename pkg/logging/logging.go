@@ -0,0 +1,124 @@
+package logging
+
+import (
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "regexp"
+    "strings"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+    "gopkg.in/natefinch/lumberjack.v2"
+)
+
+type level int
+
+const (
+    levelInfo level = iota
+    levelWarning
+    levelError
+)
+
+// Logger wraps the standard logger together with the sinks it writes to,
+// so they can be flushed and closed on shutdown.
+type Logger struct {
+    *log.Logger
+    closers []io.Closer
+}
+
+// New creates a logger writing to a size-rotated log file (or stdout when no
+// file is configured) and, if enabled, to the platform's native log sink.
+// source is the name used to register with the native sink.
+func New(cfg config.LoggingConfig, source string) (*Logger, error) {
+    l := &Logger{}
+    var writers []io.Writer
+
+    if cfg.LogFile != "" {
+        // lumberjack keeps every rotated file when MaxBackups is 0
+        backups := cfg.MaxBackups
+        if backups < 0 {
+            backups = 0
+        }
+        rotator := &lumberjack.Logger{
+            Filename:   cfg.LogFile,
+            MaxSize:    cfg.MaxSizeMB,
+            MaxAge:     cfg.MaxAgeDays,
+            MaxBackups: backups,
+            Compress:   cfg.Compress,
+            LocalTime:  true,
+        }
+        writers = append(writers, rotator)
+        l.closers = append(l.closers, rotator)
+    }
+
+    native, err := newNativeSink(cfg, source)
+    if err != nil {
+        return nil, fmt.Errorf("opening native log sink: %w", err)
+    }
+    if native != nil {
+        writers = append(writers, native)
+        l.closers = append(l.closers, native)
+    }
+
+    // Fall back to stdout when nothing else is configured. When a native sink
+    // is active stdout is skipped, since the service manager would capture it
+    // into the same log a second time.
+    if len(writers) == 0 {
+        writers = append(writers, os.Stdout)
+    }
+
+    flags := log.LstdFlags
+    if cfg.Verbose {
+        flags |= log.Lshortfile
+    }
+    l.Logger = log.New(io.MultiWriter(writers...), "", flags)
+
+    return l, nil
+}
+
+// Close flushes and closes all sinks.
+func (l *Logger) Close() error {
+    var firstErr error
+    for _, c := range l.closers {
+        if err := c.Close(); err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+    return firstErr
+}
+
+// Level prefixes that log messages start with to mark their severity.
+// Messages without one are informational.
+const (
+    errorPrefix   = "ERROR: "
+    warningPrefix = "WARNING: "
+)
+
+// shortFile matches the file:line header added in verbose mode
+var shortFile = regexp.MustCompile(`^[\w.-]+\.go:\d+: `)
+
+// classify reads the severity of a log line from its level prefix, so native
+// sinks can show warnings and errors with the right level.
+func classify(line string) level {
+    msg := message(line)
+    switch {
+    case strings.HasPrefix(msg, errorPrefix):
+        return levelError
+    case strings.HasPrefix(msg, warningPrefix):
+        return levelWarning
+    default:
+        return levelInfo
+    }
+}
+
+// message strips the date, time and optional file:line header the standard
+// logger puts in front of every line.
+func message(line string) string {
+    for i := 0; i < 2; i++ {
+        if _, rest, ok := strings.Cut(line, " "); ok {
+            line = rest
+        }
+    }
+    return strings.TrimPrefix(line, shortFile.FindString(line))
+}
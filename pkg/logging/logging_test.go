@@ -0,0 +1,24 @@
+package logging
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+    tests := []struct {
+        line string
+        want level
+    }{
+        {"2026/10/18 08:00:00 Upload successful: clip (UUID: 1)", levelInfo},
+        {"2026/10/18 08:00:00 Holding clip.mp4: retrying failed upload (next attempt in 30s)", levelInfo},
+        {"2026/10/18 08:00:00 Error is not retryable, moving to failed folder", levelInfo},
+        {"2026/10/18 08:00:00 WARNING: Upload failed: connection reset", levelWarning},
+        {"2026/10/18 08:00:00 ERROR: Max retries reached, moving to failed folder", levelError},
+        {"2026/10/18 08:00:00 handler.go:414: ERROR: Upload failed: bad request", levelError},
+        {"2026/10/18 08:00:00 watcher.go:286: WARNING: restart the service", levelWarning},
+        {"2026/10/18 08:00:00 Upload failed: WARNING: in the middle", levelInfo},
+    }
+    for _, tt := range tests {
+        if got := classify(tt.line); got != tt.want {
+            t.Errorf("classify(%q) = %d, want %d", tt.line, got, tt.want)
+        }
+    }
+}
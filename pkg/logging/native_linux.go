@@ -0,0 +1,52 @@
+//go:build linux
+
+package logging
+
+import (
+    "io"
+    "os"
+    "strings"
+
+    "github.com/coreos/go-systemd/v22/journal"
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+)
+
+type journaldSink struct {
+    identifier string
+}
+
+// newNativeSink returns a journald sink when enabled and running under systemd.
+func newNativeSink(cfg config.LoggingConfig, source string) (io.WriteCloser, error) {
+    if !cfg.Journald {
+        return nil, nil
+    }
+
+    // systemd sets INVOCATION_ID for every unit it starts
+    if os.Getenv("INVOCATION_ID") == "" || !journal.Enabled() {
+        return nil, nil
+    }
+
+    return &journaldSink{identifier: source}, nil
+}
+
+func (s *journaldSink) Write(p []byte) (int, error) {
+    msg := strings.TrimRight(string(p), "\n")
+
+    priority := journal.PriInfo
+    switch classify(msg) {
+    case levelWarning:
+        priority = journal.PriWarning
+    case levelError:
+        priority = journal.PriErr
+    }
+
+    vars := map[string]string{"SYSLOG_IDENTIFIER": s.identifier}
+    if err := journal.Send(msg, priority, vars); err != nil {
+        return 0, err
+    }
+    return len(p), nil
+}
+
+func (s *journaldSink) Close() error {
+    return nil
+}
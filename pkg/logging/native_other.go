@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+
+package logging
+
+import (
+    "io"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+)
+
+// newNativeSink returns nil; there is no native sink on this platform.
+func newNativeSink(cfg config.LoggingConfig, source string) (io.WriteCloser, error) {
+    return nil, nil
+}
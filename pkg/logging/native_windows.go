@@ -0,0 +1,67 @@
+//go:build windows
+
+package logging
+
+import (
+    "io"
+    "strings"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+    "golang.org/x/sys/windows/svc"
+    "golang.org/x/sys/windows/svc/eventlog"
+)
+
+// Event IDs used for the Event Log entries
+const (
+    eventIDInfo    = 1
+    eventIDWarning = 2
+    eventIDError   = 3
+)
+
+type eventLogSink struct {
+    log *eventlog.Log
+}
+
+// newNativeSink returns a Windows Event Log sink when enabled and running as a service.
+func newNativeSink(cfg config.LoggingConfig, source string) (io.WriteCloser, error) {
+    if !cfg.EventLog {
+        return nil, nil
+    }
+
+    isService, err := svc.IsWindowsService()
+    if err != nil || !isService {
+        return nil, nil
+    }
+
+    // Register the event source; this fails harmlessly if it already exists
+    _ = eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Warning|eventlog.Info)
+
+    l, err := eventlog.Open(source)
+    if err != nil {
+        return nil, err
+    }
+
+    return &eventLogSink{log: l}, nil
+}
+
+func (s *eventLogSink) Write(p []byte) (int, error) {
+    msg := strings.TrimRight(string(p), "\r\n")
+
+    var err error
+    switch classify(msg) {
+    case levelWarning:
+        err = s.log.Warning(eventIDWarning, msg)
+    case levelError:
+        err = s.log.Error(eventIDError, msg)
+    default:
+        err = s.log.Info(eventIDInfo, msg)
+    }
+    if err != nil {
+        return 0, err
+    }
+    return len(p), nil
+}
+
+func (s *eventLogSink) Close() error {
+    return s.log.Close()
+}
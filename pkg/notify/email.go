@@ -0,0 +1,272 @@
+package notify
+
+import (
+    "crypto/tls"
+    "fmt"
+    "io/fs"
+    "log"
+    "mime"
+    "net"
+    "net/smtp"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+    "github.com/dsu-teknik/peertube-monitor/pkg/peertube"
+)
+
+const smtpTimeout = 30 * time.Second
+
+// Email sends a message for every permanently failed upload and, if enabled,
+// a daily digest of uploads, failures and folder disk usage.
+type Email struct {
+    cfg     config.EmailConfig
+    folders []string
+    logger  *log.Logger
+
+    mu        sync.Mutex
+    succeeded []Event
+    failed    []Event
+
+    wg        sync.WaitGroup
+    done      chan struct{}
+    closeOnce sync.Once
+}
+
+// NewEmail starts the email target. cfg is checked by config.Validate; the
+// TLS mode is checked again here so an unknown mode can never send in
+// plaintext.
+func NewEmail(cfg config.EmailConfig, folders []string, logger *log.Logger) (*Email, error) {
+    switch cfg.TLS {
+    case "starttls", "tls", "none":
+    default:
+        return nil, fmt.Errorf("email: invalid tls mode %q (must be starttls, tls or none)", cfg.TLS)
+    }
+
+    digestAt, err := time.Parse("15:04", cfg.DigestTime)
+    if err != nil {
+        return nil, fmt.Errorf("email: invalid digestTime %q (must be HH:MM)", cfg.DigestTime)
+    }
+
+    e := &Email{
+        cfg:     cfg,
+        folders: folders,
+        logger:  logger,
+        done:    make(chan struct{}),
+    }
+
+    if cfg.Digest {
+        e.wg.Add(1)
+        go e.runDigest(digestAt.Hour(), digestAt.Minute())
+    }
+
+    return e, nil
+}
+
+func (e *Email) Notify(event Event) {
+    switch event.Type {
+    case EventUploadSucceeded:
+        e.mu.Lock()
+        e.succeeded = append(e.succeeded, event)
+        e.mu.Unlock()
+
+    case EventUploadFailed:
+        e.mu.Lock()
+        e.failed = append(e.failed, event)
+        e.mu.Unlock()
+
+        e.wg.Add(1)
+        go func() {
+            defer e.wg.Done()
+            if err := e.Send(failureSubject(event), failureBody(event)); err != nil {
+                e.logger.Printf("ERROR: Email: sending failure notification failed: %v", err)
+            }
+        }()
+    }
+}
+
+// Close stops the digest scheduler and waits for messages being sent
+func (e *Email) Close() {
+    e.closeOnce.Do(func() {
+        close(e.done)
+    })
+    e.wg.Wait()
+}
+
+func (e *Email) runDigest(hour, minute int) {
+    defer e.wg.Done()
+
+    for {
+        now := time.Now()
+        next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+        if !next.After(now) {
+            next = next.AddDate(0, 0, 1)
+        }
+
+        select {
+        case <-e.done:
+            return
+        case <-time.After(time.Until(next)):
+            if err := e.SendDigest(); err != nil {
+                e.logger.Printf("ERROR: Email: sending daily digest failed: %v", err)
+            }
+        }
+    }
+}
+
+// SendDigest sends the summary of everything since the previous digest
+func (e *Email) SendDigest() error {
+    e.mu.Lock()
+    succeeded, failed := e.succeeded, e.failed
+    e.succeeded, e.failed = nil, nil
+    e.mu.Unlock()
+
+    var b strings.Builder
+    fmt.Fprintf(&b, "Uploads: %d succeeded, %d failed\r\n", len(succeeded), len(failed))
+
+    if len(succeeded) > 0 {
+        b.WriteString("\r\nUploaded:\r\n")
+        for _, ev := range succeeded {
+            fmt.Fprintf(&b, "  - %s %s\r\n", ev.Name, ev.URL)
+        }
+    }
+
+    if len(failed) > 0 {
+        b.WriteString("\r\nFailed:\r\n")
+        for _, ev := range failed {
+            fmt.Fprintf(&b, "  - %s: %s\r\n", filepath.Base(ev.File), ev.Error)
+        }
+    }
+
+    b.WriteString("\r\nDisk usage:\r\n")
+    for _, folder := range e.folders {
+        if folder == "" {
+            continue
+        }
+        files, size, err := folderUsage(folder)
+        if err != nil {
+            fmt.Fprintf(&b, "  - %s: %v\r\n", folder, err)
+            continue
+        }
+        fmt.Fprintf(&b, "  - %s: %d files, %s\r\n", folder, files, peertube.FormatBytes(size))
+    }
+
+    subject := fmt.Sprintf("PeerTube Monitor daily digest: %d uploaded, %d failed", len(succeeded), len(failed))
+    return e.Send(subject, b.String())
+}
+
+// Send delivers a plain text message to all recipients
+func (e *Email) Send(subject, body string) error {
+    addr := net.JoinHostPort(e.cfg.Host, strconv.Itoa(e.cfg.Port))
+    tlsConfig := &tls.Config{ServerName: e.cfg.Host}
+
+    var conn net.Conn
+    var err error
+    dialer := &net.Dialer{Timeout: smtpTimeout}
+    if e.cfg.TLS == "tls" {
+        conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+    } else {
+        conn, err = dialer.Dial("tcp", addr)
+    }
+    if err != nil {
+        return fmt.Errorf("connecting to %s: %w", addr, err)
+    }
+    conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+    client, err := smtp.NewClient(conn, e.cfg.Host)
+    if err != nil {
+        conn.Close()
+        return fmt.Errorf("starting SMTP session: %w", err)
+    }
+    defer client.Close()
+
+    // Never fall back to plaintext: credentials and message would go out
+    // unencrypted without anyone noticing
+    if e.cfg.TLS == "starttls" {
+        if ok, _ := client.Extension("STARTTLS"); !ok {
+            return fmt.Errorf("%s does not support STARTTLS (set tls to \"tls\" or \"none\")", addr)
+        }
+        if err := client.StartTLS(tlsConfig); err != nil {
+            return fmt.Errorf("starttls: %w", err)
+        }
+    }
+
+    if e.cfg.Username != "" {
+        if ok, _ := client.Extension("AUTH"); !ok {
+            return fmt.Errorf("%s does not support authentication (remove username to send without it)", addr)
+        }
+        if err := client.Auth(smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, e.cfg.Host)); err != nil {
+            return fmt.Errorf("authenticating: %w", err)
+        }
+    }
+
+    if err := client.Mail(e.cfg.From); err != nil {
+        return fmt.Errorf("mail from: %w", err)
+    }
+    for _, to := range e.cfg.To {
+        if err := client.Rcpt(to); err != nil {
+            return fmt.Errorf("rcpt to %s: %w", to, err)
+        }
+    }
+
+    w, err := client.Data()
+    if err != nil {
+        return fmt.Errorf("data: %w", err)
+    }
+    if _, err := w.Write(e.buildMessage(subject, body)); err != nil {
+        return fmt.Errorf("writing message: %w", err)
+    }
+    if err := w.Close(); err != nil {
+        return fmt.Errorf("sending message: %w", err)
+    }
+
+    return client.Quit()
+}
+
+func (e *Email) buildMessage(subject, body string) []byte {
+    var b strings.Builder
+    fmt.Fprintf(&b, "From: %s\r\n", e.cfg.From)
+    fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.cfg.To, ", "))
+    fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+    fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+    b.WriteString("MIME-Version: 1.0\r\n")
+    b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+    b.WriteString("\r\n")
+    b.WriteString(body)
+    return []byte(b.String())
+}
+
+func failureSubject(event Event) string {
+    return fmt.Sprintf("PeerTube Monitor: upload failed for %s", filepath.Base(event.File))
+}
+
+func failureBody(event Event) string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "The upload of %s failed permanently and the file was moved aside.\r\n\r\n", event.File)
+    fmt.Fprintf(&b, "Time:     %s\r\n", event.Time.Format(time.RFC1123))
+    fmt.Fprintf(&b, "Attempts: %d\r\n", event.Attempts)
+    fmt.Fprintf(&b, "Error:    %s\r\n", event.Error)
+    return b.String()
+}
+
+func folderUsage(root string) (files int, size int64, err error) {
+    err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if d.IsDir() {
+            return nil
+        }
+        info, err := d.Info()
+        if err != nil {
+            return nil
+        }
+        files++
+        size += info.Size()
+        return nil
+    })
+    return files, size, err
+}
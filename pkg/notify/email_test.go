@@ -0,0 +1,338 @@
+package notify
+
+import (
+    "bufio"
+    "encoding/base64"
+    "io"
+    "net"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+)
+
+// smtpServer is a minimal SMTP stand-in that records the messages it receives
+type smtpServer struct {
+    ln         net.Listener
+    extensions []string // advertised in the EHLO reply
+
+    mu       sync.Mutex
+    messages []smtpMessage
+    auth     string // decoded AUTH PLAIN credentials of the last session
+}
+
+type smtpMessage struct {
+    from string
+    to   []string
+    data string
+}
+
+func newSMTPServer(t *testing.T, extensions ...string) *smtpServer {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    s := &smtpServer{ln: ln, extensions: extensions}
+    t.Cleanup(func() { ln.Close() })
+    go s.serve()
+    return s
+}
+
+func (s *smtpServer) port() int {
+    return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) received() []smtpMessage {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return append([]smtpMessage(nil), s.messages...)
+}
+
+func (s *smtpServer) serve() {
+    for {
+        conn, err := s.ln.Accept()
+        if err != nil {
+            return
+        }
+        go s.session(conn)
+    }
+}
+
+func (s *smtpServer) session(conn net.Conn) {
+    defer conn.Close()
+    r := bufio.NewReader(conn)
+    reply := func(line string) {
+        io.WriteString(conn, line+"\r\n")
+    }
+
+    reply("220 localhost ESMTP test")
+    var msg smtpMessage
+    for {
+        line, err := r.ReadString('\n')
+        if err != nil {
+            return
+        }
+        line = strings.TrimRight(line, "\r\n")
+        verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+        switch verb {
+        case "EHLO", "HELO":
+            lines := append([]string{"localhost"}, s.extensions...)
+            for i, l := range lines {
+                sep := "-"
+                if i == len(lines)-1 {
+                    sep = " "
+                }
+                reply("250" + sep + l)
+            }
+        case "AUTH":
+            fields := strings.Fields(line)
+            if len(fields) == 3 {
+                creds, _ := base64.StdEncoding.DecodeString(fields[2])
+                s.mu.Lock()
+                s.auth = string(creds)
+                s.mu.Unlock()
+            }
+            reply("235 authenticated")
+        case "MAIL":
+            msg = smtpMessage{from: strings.TrimPrefix(line, "MAIL FROM:")}
+            reply("250 ok")
+        case "RCPT":
+            msg.to = append(msg.to, strings.TrimPrefix(line, "RCPT TO:"))
+            reply("250 ok")
+        case "DATA":
+            reply("354 go ahead")
+            var data strings.Builder
+            for {
+                l, err := r.ReadString('\n')
+                if err != nil {
+                    return
+                }
+                if l == ".\r\n" {
+                    break
+                }
+                data.WriteString(l)
+            }
+            msg.data = data.String()
+            s.mu.Lock()
+            s.messages = append(s.messages, msg)
+            s.mu.Unlock()
+            reply("250 queued")
+        case "QUIT":
+            reply("221 bye")
+            return
+        default:
+            reply("502 not implemented")
+        }
+    }
+}
+
+func testEmailConfig(s *smtpServer) config.EmailConfig {
+    return config.EmailConfig{
+        Host:       "127.0.0.1",
+        Port:       s.port(),
+        From:       "monitor@example.com",
+        To:         []string{"ops@example.com", "media@example.com"},
+        TLS:        "none",
+        DigestTime: "08:00",
+    }
+}
+
+func TestEmailFailureNotification(t *testing.T) {
+    srv := newSMTPServer(t)
+
+    e, err := NewEmail(testEmailConfig(srv), nil, testLogger(io.Discard))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    e.Notify(Event{Type: EventUploadStarted, File: "/watch/clip.mp4"})
+    e.Notify(Event{
+        Type:     EventUploadFailed,
+        Time:     time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+        File:     "/watch/clip.mp4",
+        Error:    "400 Bad Request - invalid file",
+        Attempts: 3,
+    })
+    e.Close()
+
+    msgs := srv.received()
+    if len(msgs) != 1 {
+        t.Fatalf("received %d messages, want 1", len(msgs))
+    }
+    msg := msgs[0]
+    if msg.from != "<monitor@example.com>" {
+        t.Errorf("from = %q", msg.from)
+    }
+    if len(msg.to) != 2 {
+        t.Errorf("to = %q", msg.to)
+    }
+    for _, want := range []string{
+        "Subject: PeerTube Monitor: upload failed for clip.mp4\r\n",
+        "To: ops@example.com, media@example.com\r\n",
+        "The upload of /watch/clip.mp4 failed permanently",
+        "Attempts: 3\r\n",
+        "Error:    400 Bad Request - invalid file\r\n",
+    } {
+        if !strings.Contains(msg.data, want) {
+            t.Errorf("message does not contain %q:\n%s", want, msg.data)
+        }
+    }
+}
+
+func TestEmailDigest(t *testing.T) {
+    srv := newSMTPServer(t)
+
+    watch := t.TempDir()
+    if err := os.WriteFile(filepath.Join(watch, "a.mp4"), make([]byte, 2048), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(filepath.Join(watch, "b.mp4"), make([]byte, 1024), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    e, err := NewEmail(testEmailConfig(srv), []string{watch, ""}, testLogger(io.Discard))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer e.Close()
+
+    e.Notify(Event{Type: EventUploadSucceeded, File: "/watch/one.mp4", Name: "One", URL: "https://videos.example.com/w/1"})
+    e.Notify(Event{Type: EventUploadSucceeded, File: "/watch/two.mp4", Name: "Two", URL: "https://videos.example.com/w/2"})
+    e.Notify(Event{Type: EventUploadFailed, File: "/watch/bad.mp4", Error: "server said no"})
+
+    if err := e.SendDigest(); err != nil {
+        t.Fatal(err)
+    }
+    e.Close()
+
+    var digest string
+    for _, m := range srv.received() {
+        if strings.Contains(m.data, "daily digest") {
+            digest = m.data
+        }
+    }
+    if digest == "" {
+        t.Fatal("no digest received")
+    }
+    for _, want := range []string{
+        "Subject: PeerTube Monitor daily digest: 2 uploaded, 1 failed\r\n",
+        "Uploads: 2 succeeded, 1 failed\r\n",
+        "  - One https://videos.example.com/w/1\r\n",
+        "  - Two https://videos.example.com/w/2\r\n",
+        "  - bad.mp4: server said no\r\n",
+        "  - " + watch + ": 2 files, 3.0 KiB\r\n",
+    } {
+        if !strings.Contains(digest, want) {
+            t.Errorf("digest does not contain %q:\n%s", want, digest)
+        }
+    }
+}
+
+func TestEmailDigestStartsOver(t *testing.T) {
+    srv := newSMTPServer(t)
+
+    e, err := NewEmail(testEmailConfig(srv), nil, testLogger(io.Discard))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer e.Close()
+
+    e.Notify(Event{Type: EventUploadSucceeded, Name: "One"})
+    if err := e.SendDigest(); err != nil {
+        t.Fatal(err)
+    }
+    if err := e.SendDigest(); err != nil {
+        t.Fatal(err)
+    }
+
+    msgs := srv.received()
+    if len(msgs) != 2 {
+        t.Fatalf("received %d messages, want 2", len(msgs))
+    }
+    if !strings.Contains(msgs[1].data, "Uploads: 0 succeeded, 0 failed") {
+        t.Errorf("second digest repeats earlier uploads:\n%s", msgs[1].data)
+    }
+}
+
+func TestEmailAuthentication(t *testing.T) {
+    srv := newSMTPServer(t, "AUTH PLAIN")
+
+    cfg := testEmailConfig(srv)
+    cfg.Username = "monitor"
+    cfg.Password = "secret"
+    e, err := NewEmail(cfg, nil, testLogger(io.Discard))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer e.Close()
+
+    if err := e.Send("hello", "body"); err != nil {
+        t.Fatal(err)
+    }
+    srv.mu.Lock()
+    defer srv.mu.Unlock()
+    if srv.auth != "\x00monitor\x00secret" {
+        t.Errorf("auth = %q", srv.auth)
+    }
+}
+
+func TestEmailRequiresAuthWhenConfigured(t *testing.T) {
+    srv := newSMTPServer(t)
+
+    cfg := testEmailConfig(srv)
+    cfg.Username = "monitor"
+    cfg.Password = "secret"
+    e, err := NewEmail(cfg, nil, testLogger(io.Discard))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer e.Close()
+
+    err = e.Send("hello", "body")
+    if err == nil || !strings.Contains(err.Error(), "does not support authentication") {
+        t.Fatalf("err = %v, want missing AUTH error", err)
+    }
+    if n := len(srv.received()); n != 0 {
+        t.Errorf("sent %d messages without authenticating", n)
+    }
+}
+
+func TestEmailRequiresStartTLS(t *testing.T) {
+    srv := newSMTPServer(t)
+
+    cfg := testEmailConfig(srv)
+    cfg.TLS = "starttls"
+    e, err := NewEmail(cfg, nil, testLogger(io.Discard))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer e.Close()
+
+    err = e.Send("hello", "body")
+    if err == nil || !strings.Contains(err.Error(), "does not support STARTTLS") {
+        t.Fatalf("err = %v, want missing STARTTLS error", err)
+    }
+    if n := len(srv.received()); n != 0 {
+        t.Errorf("sent %d messages in plaintext", n)
+    }
+}
+
+func TestNewEmailValidation(t *testing.T) {
+    base := config.EmailConfig{Host: "localhost", Port: 25, From: "a@example.com", To: []string{"b@example.com"}, TLS: "none", DigestTime: "08:00"}
+
+    for name, modify := range map[string]func(*config.EmailConfig){
+        "bad tls":    func(c *config.EmailConfig) { c.TLS = "ssl" },
+        "bad digest": func(c *config.EmailConfig) { c.DigestTime = "8am" },
+    } {
+        cfg := base
+        modify(&cfg)
+        if _, err := NewEmail(cfg, nil, testLogger(io.Discard)); err == nil {
+            t.Errorf("%s: expected an error", name)
+        }
+    }
+}
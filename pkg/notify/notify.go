@@ -0,0 +1,102 @@
+package notify
+
+import (
+    "log"
+    "time"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+)
+
+type EventType string
+
+const (
+    EventUploadStarted   EventType = "upload.started"
+    EventUploadSucceeded EventType = "upload.succeeded"
+    EventUploadFailed    EventType = "upload.failed"
+
+    // EventUploadInterrupted is sent when shutdown cancels an upload
+    EventUploadInterrupted EventType = "upload.interrupted"
+)
+
+// Event describes something that happened to a watched file
+type Event struct {
+    Type      EventType `json:"event"`
+    Time      time.Time `json:"time"`
+    File      string    `json:"file"`
+    Name      string    `json:"name,omitempty"`
+    ChannelID int       `json:"channelId,omitempty"`
+    UUID      string    `json:"uuid,omitempty"`
+    URL       string    `json:"url,omitempty"`
+    Error     string    `json:"error,omitempty"`
+    Attempts  int       `json:"attempts,omitempty"`
+}
+
+type Notifier interface {
+    Notify(event Event)
+}
+
+// target is a notification channel with background work to stop on shutdown
+type target interface {
+    Notifier
+    Close()
+}
+
+// Dispatcher fans events out to all configured notification targets
+type Dispatcher struct {
+    targets []target
+    logger  *log.Logger
+}
+
+func New(cfg *config.Config, logger *log.Logger) (*Dispatcher, error) {
+    d := &Dispatcher{logger: logger}
+
+    for _, whCfg := range cfg.Notifications.Webhooks {
+        wh, err := NewWebhook(whCfg, logger)
+        if err != nil {
+            d.Close()
+            return nil, err
+        }
+        d.targets = append(d.targets, wh)
+    }
+
+    if cfg.Notifications.Email.Host != "" {
+        folders := []string{cfg.Watcher.WatchPath, cfg.Watcher.DonePath, cfg.Watcher.FailedPath}
+        email, err := NewEmail(cfg.Notifications.Email, folders, logger)
+        if err != nil {
+            d.Close()
+            return nil, err
+        }
+        d.targets = append(d.targets, email)
+    }
+
+    return d, nil
+}
+
+func (d *Dispatcher) Notify(event Event) {
+    if event.Time.IsZero() {
+        event.Time = time.Now()
+    }
+
+    for _, t := range d.targets {
+        t.Notify(event)
+    }
+}
+
+// Close stops all targets, giving queued deliveries a chance to go out first
+func (d *Dispatcher) Close() {
+    for _, t := range d.targets {
+        t.Close()
+    }
+}
+
+func wantsEvent(events []string, t EventType) bool {
+    if len(events) == 0 {
+        return true
+    }
+    for _, e := range events {
+        if e == string(t) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,243 @@
+package notify
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "text/template"
+    "time"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+)
+
+const (
+    webhookQueueSize  = 100
+    webhookMaxBackoff = 5 * time.Minute
+
+    // SignatureHeader carries the hex encoded HMAC-SHA256 of the request body
+    SignatureHeader = "X-PeerTube-Monitor-Signature"
+    // EventHeader carries the event type of the delivery
+    EventHeader = "X-PeerTube-Monitor-Event"
+)
+
+// Webhook delivers events as JSON POST requests to a single URL. Deliveries
+// are queued and sent in the background; failed deliveries are retried with
+// exponential backoff up to MaxRetries times.
+type Webhook struct {
+    cfg        config.WebhookConfig
+    maxRetries int
+    tmpl       *template.Template
+    httpClient *http.Client
+    logger     *log.Logger
+
+    queue     chan delivery
+    done      chan struct{}
+    stopped   chan struct{}
+    closeOnce sync.Once
+}
+
+type delivery struct {
+    event    Event
+    body     []byte
+    attempts int
+    next     time.Time
+}
+
+// NewWebhook starts the webhook target. cfg is checked by config.Validate,
+// and config.Load sets MaxRetries when it is missing; nil means no retries.
+func NewWebhook(cfg config.WebhookConfig, logger *log.Logger) (*Webhook, error) {
+    w := &Webhook{
+        cfg:        cfg,
+        httpClient: &http.Client{},
+        logger:     logger,
+        queue:      make(chan delivery, webhookQueueSize),
+        done:       make(chan struct{}),
+        stopped:    make(chan struct{}),
+    }
+    if cfg.MaxRetries != nil {
+        w.maxRetries = *cfg.MaxRetries
+    }
+
+    if cfg.Template != "" {
+        tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+            "json": toJSON,
+        }).Parse(cfg.Template)
+        if err != nil {
+            return nil, fmt.Errorf("webhook %s: parsing template: %w", cfg.URL, err)
+        }
+        w.tmpl = tmpl
+    }
+
+    go w.run()
+    return w, nil
+}
+
+func (w *Webhook) Notify(event Event) {
+    if !wantsEvent(w.cfg.Events, event.Type) {
+        return
+    }
+
+    body, err := w.render(event)
+    if err != nil {
+        w.logger.Printf("ERROR: Webhook %s: %v", w.cfg.URL, err)
+        return
+    }
+
+    select {
+    case <-w.done:
+    case w.queue <- delivery{event: event, body: body}:
+    default:
+        w.logger.Printf("WARNING: Webhook %s: queue full, dropping %s event for %s", w.cfg.URL, event.Type, event.File)
+    }
+}
+
+// Close stops the delivery worker. Queued deliveries get one last attempt;
+// pending retries are dropped.
+func (w *Webhook) Close() {
+    w.closeOnce.Do(func() {
+        close(w.done)
+    })
+    <-w.stopped
+}
+
+func (w *Webhook) render(event Event) ([]byte, error) {
+    if w.tmpl == nil {
+        return json.Marshal(event)
+    }
+
+    var buf bytes.Buffer
+    if err := w.tmpl.Execute(&buf, event); err != nil {
+        return nil, fmt.Errorf("rendering template: %w", err)
+    }
+    if !json.Valid(buf.Bytes()) {
+        return nil, fmt.Errorf("template did not produce valid JSON: %s", buf.String())
+    }
+    return buf.Bytes(), nil
+}
+
+func (w *Webhook) run() {
+    defer close(w.stopped)
+
+    // Pending retries, ordered by next attempt time
+    var retries []delivery
+
+    for {
+        var retryC <-chan time.Time
+        if len(retries) > 0 {
+            retryC = time.After(time.Until(retries[0].next))
+        }
+
+        select {
+        case d := <-w.queue:
+            retries = w.attempt(d, retries)
+
+        case <-retryC:
+            d := retries[0]
+            retries = retries[1:]
+            retries = w.attempt(d, retries)
+
+        case <-w.done:
+            for {
+                select {
+                case d := <-w.queue:
+                    if err := w.send(d); err != nil {
+                        w.logger.Printf("ERROR: Webhook %s: delivery of %s event failed: %v", w.cfg.URL, d.event.Type, err)
+                    }
+                default:
+                    if len(retries) > 0 {
+                        w.logger.Printf("WARNING: Webhook %s: dropping %d pending retries", w.cfg.URL, len(retries))
+                    }
+                    return
+                }
+            }
+        }
+    }
+}
+
+// attempt sends a delivery and, on failure, schedules it in the retry queue
+func (w *Webhook) attempt(d delivery, retries []delivery) []delivery {
+    err := w.send(d)
+    if err == nil {
+        return retries
+    }
+
+    d.attempts++
+    if d.attempts > w.maxRetries {
+        w.logger.Printf("ERROR: Webhook %s: giving up on %s event for %s after %d attempts: %v",
+            w.cfg.URL, d.event.Type, d.event.File, d.attempts, err)
+        return retries
+    }
+
+    backoff := time.Duration(1<<uint(d.attempts)) * time.Second
+    if backoff > webhookMaxBackoff {
+        backoff = webhookMaxBackoff
+    }
+    d.next = time.Now().Add(backoff)
+    w.logger.Printf("WARNING: Webhook %s: delivery failed, retrying in %s (%d/%d): %v",
+        w.cfg.URL, backoff, d.attempts, w.maxRetries, err)
+
+    retries = append(retries, d)
+    sort.SliceStable(retries, func(i, j int) bool {
+        return retries[i].next.Before(retries[j].next)
+    })
+    return retries
+}
+
+func (w *Webhook) send(d delivery) error {
+    ctx, cancel := context.WithTimeout(context.Background(), time.Duration(w.cfg.Timeout)*time.Second)
+    defer cancel()
+
+    req, err := http.NewRequestWithContext(ctx, "POST", w.cfg.URL, bytes.NewReader(d.body))
+    if err != nil {
+        return fmt.Errorf("creating request: %w", err)
+    }
+
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("User-Agent", "peertube-monitor")
+    req.Header.Set(EventHeader, string(d.event.Type))
+    for key, val := range w.cfg.Headers {
+        req.Header.Set(key, val)
+    }
+    if w.cfg.Secret != "" {
+        req.Header.Set(SignatureHeader, "sha256="+Sign(w.cfg.Secret, d.body))
+    }
+
+    resp, err := w.httpClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+        return fmt.Errorf("%s - %s", resp.Status, strings.TrimSpace(string(body)))
+    }
+
+    return nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body using secret, as sent in
+// the signature header. Receivers can use it to verify deliveries.
+func Sign(secret string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(body)
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+func toJSON(v interface{}) (string, error) {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return "", err
+    }
+    return string(data), nil
+}
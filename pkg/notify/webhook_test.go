@@ -0,0 +1,316 @@
+package notify
+
+import (
+    "bytes"
+    "encoding/json"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+)
+
+// receiver records the requests sent to a test webhook endpoint
+type receiver struct {
+    mu       sync.Mutex
+    requests []received
+    fail     int // number of requests to answer with 500 before succeeding
+    got      chan struct{}
+}
+
+type received struct {
+    header http.Header
+    body   []byte
+    at     time.Time
+}
+
+func newReceiver(t *testing.T, fail int) (*receiver, *httptest.Server) {
+    r := &receiver{fail: fail, got: make(chan struct{}, 10)}
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        body, _ := io.ReadAll(req.Body)
+
+        r.mu.Lock()
+        r.requests = append(r.requests, received{header: req.Header.Clone(), body: body, at: time.Now()})
+        failing := len(r.requests) <= r.fail
+        r.mu.Unlock()
+
+        if failing {
+            http.Error(w, "try again later", http.StatusInternalServerError)
+        }
+        r.got <- struct{}{}
+    }))
+    t.Cleanup(srv.Close)
+    return r, srv
+}
+
+func (r *receiver) wait(t *testing.T, n int, timeout time.Duration) []received {
+    t.Helper()
+    deadline := time.After(timeout)
+    for i := 0; i < n; i++ {
+        select {
+        case <-r.got:
+        case <-deadline:
+            t.Fatalf("timed out waiting for request %d of %d", i+1, n)
+        }
+    }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return append([]received(nil), r.requests...)
+}
+
+func (r *receiver) count() int {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return len(r.requests)
+}
+
+// retries returns n as a maxRetries setting
+func retries(n int) *int {
+    return &n
+}
+
+// logBuffer collects log output written from background goroutines
+type logBuffer struct {
+    mu  sync.Mutex
+    buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.String()
+}
+
+func testLogger(w io.Writer) *log.Logger {
+    return log.New(w, "", 0)
+}
+
+func TestWebhookSignature(t *testing.T) {
+    r, srv := newReceiver(t, 0)
+
+    wh, err := NewWebhook(config.WebhookConfig{
+        URL:        srv.URL,
+        Secret:     "s3cret",
+        Headers:    map[string]string{"Authorization": "Bearer token"},
+        MaxRetries: retries(1),
+        Timeout:    5,
+    }, testLogger(io.Discard))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer wh.Close()
+
+    wh.Notify(Event{Type: EventUploadSucceeded, File: "/watch/clip.mp4", UUID: "abc"})
+    req := r.wait(t, 1, 5*time.Second)[0]
+
+    want := "sha256=" + Sign("s3cret", req.body)
+    if got := req.header.Get(SignatureHeader); got != want {
+        t.Errorf("signature = %q, want %q", got, want)
+    }
+    if got := req.header.Get(EventHeader); got != string(EventUploadSucceeded) {
+        t.Errorf("event header = %q", got)
+    }
+    if got := req.header.Get("Authorization"); got != "Bearer token" {
+        t.Errorf("custom header = %q", got)
+    }
+
+    var event Event
+    if err := json.Unmarshal(req.body, &event); err != nil {
+        t.Fatalf("body is not an event: %v", err)
+    }
+    if event.File != "/watch/clip.mp4" || event.UUID != "abc" {
+        t.Errorf("unexpected payload: %s", req.body)
+    }
+}
+
+func TestWebhookWithoutSecretIsUnsigned(t *testing.T) {
+    r, srv := newReceiver(t, 0)
+
+    wh, err := NewWebhook(config.WebhookConfig{URL: srv.URL, MaxRetries: retries(1), Timeout: 5}, testLogger(io.Discard))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer wh.Close()
+
+    wh.Notify(Event{Type: EventUploadStarted, File: "clip.mp4"})
+    req := r.wait(t, 1, 5*time.Second)[0]
+    if got := req.header.Get(SignatureHeader); got != "" {
+        t.Errorf("unexpected signature header %q", got)
+    }
+}
+
+func TestWebhookTemplate(t *testing.T) {
+    r, srv := newReceiver(t, 0)
+
+    wh, err := NewWebhook(config.WebhookConfig{
+        URL:        srv.URL,
+        Template:   `{"text": {{ printf "Uploaded %s" .Name | json }}}`,
+        MaxRetries: retries(1),
+        Timeout:    5,
+    }, testLogger(io.Discard))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer wh.Close()
+
+    wh.Notify(Event{Type: EventUploadSucceeded, Name: `My "clip"`})
+    req := r.wait(t, 1, 5*time.Second)[0]
+    if got, want := string(req.body), `{"text": "Uploaded My \"clip\""}`; got != want {
+        t.Errorf("body = %s, want %s", got, want)
+    }
+}
+
+func TestWebhookRejectsInvalidJSONTemplate(t *testing.T) {
+    r, srv := newReceiver(t, 0)
+
+    var logs logBuffer
+    wh, err := NewWebhook(config.WebhookConfig{
+        URL:        srv.URL,
+        Template:   `{"text": {{ .Name }}}`,
+        MaxRetries: retries(1),
+        Timeout:    5,
+    }, testLogger(&logs))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    wh.Notify(Event{Type: EventUploadSucceeded, Name: "not quoted"})
+    wh.Close()
+
+    if r.count() != 0 {
+        t.Errorf("sent %d requests for an invalid body", r.count())
+    }
+    if !strings.Contains(logs.String(), "template did not produce valid JSON") {
+        t.Errorf("missing error in log: %q", logs.String())
+    }
+}
+
+func TestWebhookTemplateParseError(t *testing.T) {
+    _, err := NewWebhook(config.WebhookConfig{URL: "http://localhost", Template: `{{ .Name `}, testLogger(io.Discard))
+    if err == nil {
+        t.Fatal("expected an error for a broken template")
+    }
+}
+
+func TestWebhookEventFilter(t *testing.T) {
+    r, srv := newReceiver(t, 0)
+
+    wh, err := NewWebhook(config.WebhookConfig{
+        URL:        srv.URL,
+        Events:     []string{string(EventUploadFailed)},
+        MaxRetries: retries(1),
+        Timeout:    5,
+    }, testLogger(io.Discard))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    wh.Notify(Event{Type: EventUploadStarted, File: "a.mp4"})
+    wh.Notify(Event{Type: EventUploadFailed, File: "b.mp4"})
+    reqs := r.wait(t, 1, 5*time.Second)
+    wh.Close()
+
+    if len(reqs) != 1 || reqs[0].header.Get(EventHeader) != string(EventUploadFailed) {
+        t.Errorf("expected only the failed event, got %d requests", len(reqs))
+    }
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+    r, srv := newReceiver(t, 1)
+
+    var logs logBuffer
+    wh, err := NewWebhook(config.WebhookConfig{URL: srv.URL, MaxRetries: retries(3), Timeout: 5}, testLogger(&logs))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer wh.Close()
+
+    wh.Notify(Event{Type: EventUploadFailed, File: "clip.mp4"})
+    reqs := r.wait(t, 2, 10*time.Second)
+
+    // The first retry comes after 2 seconds
+    if gap := reqs[1].at.Sub(reqs[0].at); gap < 2*time.Second {
+        t.Errorf("retried after %s, want at least 2s", gap)
+    }
+    if !bytes.Equal(reqs[0].body, reqs[1].body) {
+        t.Error("retry sent a different body")
+    }
+    if !strings.Contains(logs.String(), "retrying in 2s (1/3)") {
+        t.Errorf("missing retry in log: %q", logs.String())
+    }
+}
+
+func TestWebhookGivesUpAfterMaxRetries(t *testing.T) {
+    r, srv := newReceiver(t, 100)
+
+    var logs logBuffer
+    wh, err := NewWebhook(config.WebhookConfig{URL: srv.URL, MaxRetries: retries(1), Timeout: 5}, testLogger(&logs))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    wh.Notify(Event{Type: EventUploadFailed, File: "clip.mp4"})
+    r.wait(t, 2, 10*time.Second)
+
+    // Give the worker a moment to log the outcome of the last attempt
+    time.Sleep(100 * time.Millisecond)
+    wh.Close()
+
+    if n := r.count(); n != 2 {
+        t.Errorf("sent %d requests, want 2", n)
+    }
+    if !strings.Contains(logs.String(), "giving up on upload.failed event for clip.mp4 after 2 attempts") {
+        t.Errorf("missing give-up in log: %q", logs.String())
+    }
+}
+
+func TestWebhookWithoutRetries(t *testing.T) {
+    r, srv := newReceiver(t, 100)
+
+    var logs logBuffer
+    wh, err := NewWebhook(config.WebhookConfig{URL: srv.URL, MaxRetries: retries(0), Timeout: 5}, testLogger(&logs))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    wh.Notify(Event{Type: EventUploadFailed, File: "clip.mp4"})
+    r.wait(t, 1, 10*time.Second)
+    time.Sleep(100 * time.Millisecond)
+    wh.Close()
+
+    if n := r.count(); n != 1 {
+        t.Errorf("sent %d requests, want 1", n)
+    }
+    if !strings.Contains(logs.String(), "giving up on upload.failed event for clip.mp4 after 1 attempts") {
+        t.Errorf("missing give-up in log: %q", logs.String())
+    }
+}
+
+func TestWebhookCloseSendsQueued(t *testing.T) {
+    r, srv := newReceiver(t, 0)
+
+    wh, err := NewWebhook(config.WebhookConfig{URL: srv.URL, MaxRetries: retries(1), Timeout: 5}, testLogger(io.Discard))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for i := 0; i < 3; i++ {
+        wh.Notify(Event{Type: EventUploadStarted, File: "clip.mp4"})
+    }
+    wh.Close()
+
+    if n := r.count(); n != 3 {
+        t.Errorf("sent %d requests before closing, want 3", n)
+    }
+}
@@ -0,0 +1,81 @@
+package peertube
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// MetadataCache is the metadata and channels last fetched from a server. It
+// is kept on disk so names can still be resolved while the server is down.
+type MetadataCache struct {
+    URL      string    `json:"url"`
+    Username string    `json:"username"`
+    Fetched  time.Time `json:"fetched"`
+    Metadata
+    Channels []Channel `json:"channels"`
+}
+
+// FetchMetadataCache fetches the server's metadata and the user's channels
+func (c *Client) FetchMetadataCache(ctx context.Context) (*MetadataCache, error) {
+    metadata, err := c.FetchMetadata(ctx)
+    if err != nil {
+        return nil, err
+    }
+    channels, err := c.ListChannels(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("listing channels: %w", err)
+    }
+
+    return &MetadataCache{
+        URL:      c.baseURL,
+        Username: c.username,
+        Fetched:  time.Now(),
+        Metadata: *metadata,
+        Channels: channels,
+    }, nil
+}
+
+// LoadMetadataCache reads a cache written by Save. A cache written for
+// another server or user is not used.
+func LoadMetadataCache(path, url, username string) (*MetadataCache, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    var cache MetadataCache
+    if err := json.Unmarshal(data, &cache); err != nil {
+        return nil, fmt.Errorf("decoding metadata cache %s: %w", path, err)
+    }
+    if cache.URL != strings.TrimRight(url, "/") || cache.Username != username {
+        return nil, fmt.Errorf("metadata cache %s is for %s on %s", path, cache.Username, cache.URL)
+    }
+    return &cache, nil
+}
+
+// Save writes the cache to path. The file is replaced in one step so a
+// crash never leaves a partial cache behind.
+func (m *MetadataCache) Save(path string) error {
+    data, err := json.MarshalIndent(m, "", "  ")
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return err
+    }
+
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0644); err != nil {
+        return err
+    }
+    if err := os.Rename(tmp, path); err != nil {
+        os.Remove(tmp)
+        return err
+    }
+    return nil
+}
@@ -2,15 +2,18 @@ package peertube
 
 import (
     "bytes"
+    "context"
     "encoding/json"
     "fmt"
     "io"
     "mime/multipart"
+    "net"
     "net/http"
     "os"
     "path/filepath"
     "strconv"
     "strings"
+    "sync"
     "time"
 )
 
@@ -18,8 +21,29 @@ type Client struct {
     baseURL    string
     username   string
     password   string
-    token      string
     httpClient *http.Client
+    // uploadClient has no response header timeout: the server may take a
+    // while to answer after a large upload, which Timeouts.Response covers
+    uploadClient *http.Client
+    timeouts     Timeouts
+    limiter    *rateLimiter
+
+    mu    sync.Mutex
+    token string
+}
+
+// Timeouts limit the phases of a request. Zero disables a limit.
+type Timeouts struct {
+    Connect  time.Duration // establishing the connection and TLS handshake
+    Idle     time.Duration // no upload progress, or no response to other requests
+    Response time.Duration // waiting for the response once an upload is sent
+    Total    time.Duration // the whole request
+}
+
+// DefaultTimeouts are used until SetTimeouts is called
+var DefaultTimeouts = Timeouts{
+    Connect: 30 * time.Second,
+    Idle:    2 * time.Minute,
 }
 
 type authResponse struct {
@@ -43,15 +67,23 @@ type uploadResponse struct {
 }
 
 type userResponse struct {
-    VideoChannels []struct {
-        ID int `json:"id"`
-    } `json:"videoChannels"`
+    VideoQuota      int64 `json:"videoQuota"`
+    VideoQuotaDaily int64 `json:"videoQuotaDaily"`
+    VideoChannels   []Channel `json:"videoChannels"`
+}
+
+// Channel is one of the user's video channels
+type Channel struct {
+    ID          int    `json:"id"`
+    Name        string `json:"name"`
+    DisplayName string `json:"displayName"`
 }
 
 type Metadata struct {
-    Categories map[string]string
-    Licences   map[string]string
-    Privacies  map[string]string
+    Categories map[string]string `json:"categories"`
+    Licences   map[string]string `json:"licences"`
+    Privacies  map[string]string `json:"privacies"`
+    Languages  map[string]string `json:"languages"` // keyed by language code
 }
 
 type VideoAttributes struct {
@@ -70,27 +102,105 @@ type VideoAttributes struct {
 }
 
 func NewClient(baseURL, username, password string) *Client {
-    return &Client{
+    c := &Client{
         baseURL:  strings.TrimRight(baseURL, "/"),
         username: username,
         password: password,
-        httpClient: &http.Client{
-            Timeout: 30 * time.Minute, // Long timeout for large uploads
-        },
     }
+    c.SetTimeouts(DefaultTimeouts)
+    return c
+}
+
+// SetTimeouts replaces the request timeouts. There is deliberately no
+// blanket client timeout: large or throttled uploads may take hours, so
+// stalls are caught by the idle timeout instead.
+func (c *Client) SetTimeouts(t Timeouts) {
+    dialer := &net.Dialer{
+        Timeout:   t.Connect,
+        KeepAlive: 30 * time.Second,
+    }
+    transport := &http.Transport{
+        Proxy:                 http.ProxyFromEnvironment,
+        DialContext:           dialer.DialContext,
+        TLSHandshakeTimeout:   t.Connect,
+        ResponseHeaderTimeout: t.Idle,
+        IdleConnTimeout:       90 * time.Second,
+    }
+    uploadTransport := transport.Clone()
+    uploadTransport.ResponseHeaderTimeout = 0
+
+    c.timeouts = t
+    c.httpClient = &http.Client{Transport: transport}
+    c.uploadClient = &http.Client{Transport: uploadTransport}
+}
+
+// requestContext applies the total timeout to a request
+func (c *Client) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+    if c.timeouts.Total > 0 {
+        return context.WithTimeout(ctx, c.timeouts.Total)
+    }
+    return context.WithCancel(ctx)
+}
+
+// SetUploadRateLimit throttles uploads to bytesPerSecond in total (0 = unlimited)
+func (c *Client) SetUploadRateLimit(bytesPerSecond int64) {
+    if bytesPerSecond <= 0 {
+        c.limiter = nil
+        return
+    }
+    c.limiter = newRateLimiter(bytesPerSecond)
+}
+
+// InvalidateToken discards the access token so the next request
+// authenticates again
+func (c *Client) InvalidateToken() {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.token = ""
+}
+
+// accessToken returns the current token, authenticating first if needed
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+    c.mu.Lock()
+    token := c.token
+    c.mu.Unlock()
+
+    if token != "" {
+        return token, nil
+    }
+
+    if err := c.Authenticate(ctx); err != nil {
+        return "", fmt.Errorf("authentication required: %w", err)
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.token, nil
+}
+
+// VideoURL returns the public watch URL of a video
+func (c *Client) VideoURL(uuid string) string {
+    return c.baseURL + "/videos/watch/" + uuid
 }
 
-func (c *Client) Authenticate() error {
+func (c *Client) Authenticate(ctx context.Context) error {
+    ctx, cancel := c.requestContext(ctx)
+    defer cancel()
+
     // First get client credentials
-    resp, err := c.httpClient.Get(c.baseURL + "/api/v1/oauth-clients/local")
+    req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/api/v1/oauth-clients/local", nil)
+    if err != nil {
+        return fmt.Errorf("creating oauth clients request: %w", err)
+    }
+
+    resp, err := c.httpClient.Do(req)
     if err != nil {
         return fmt.Errorf("getting oauth clients: %w", err)
     }
     defer resp.Body.Close()
 
     if resp.StatusCode != http.StatusOK {
-        body, _ := io.ReadAll(resp.Body)
-        return fmt.Errorf("oauth clients request failed: %s - %s", resp.Status, string(body))
+        return fmt.Errorf("oauth clients request failed: %w", newAPIError(resp))
     }
 
     var creds clientCredentials
@@ -102,7 +212,7 @@ func (c *Client) Authenticate() error {
     tokenData := fmt.Sprintf("client_id=%s&client_secret=%s&grant_type=password&response_type=code&username=%s&password=%s",
         creds.ClientID, creds.ClientSecret, c.username, c.password)
 
-    req, err := http.NewRequest("POST", c.baseURL+"/api/v1/users/token", strings.NewReader(tokenData))
+    req, err = http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/v1/users/token", strings.NewReader(tokenData))
     if err != nil {
         return fmt.Errorf("creating token request: %w", err)
     }
@@ -116,8 +226,7 @@ func (c *Client) Authenticate() error {
     defer resp.Body.Close()
 
     if resp.StatusCode != http.StatusOK {
-        body, _ := io.ReadAll(resp.Body)
-        return fmt.Errorf("authentication failed: %s - %s", resp.Status, string(body))
+        return fmt.Errorf("authentication failed: %w", newAPIError(resp))
     }
 
     var auth authResponse
@@ -125,72 +234,95 @@ func (c *Client) Authenticate() error {
         return fmt.Errorf("decoding auth response: %w", err)
     }
 
+    c.mu.Lock()
     c.token = auth.AccessToken
+    c.mu.Unlock()
     return nil
 }
 
-func (c *Client) GetUserChannel() (int, error) {
-    if c.token == "" {
-        if err := c.Authenticate(); err != nil {
-            return 0, fmt.Errorf("authentication required: %w", err)
-        }
+// ListChannels returns the user's video channels
+func (c *Client) ListChannels(ctx context.Context) ([]Channel, error) {
+    user, err := c.getUser(ctx)
+    if err != nil {
+        return nil, err
     }
+    return user.VideoChannels, nil
+}
+
+// GetUserChannel returns the ID of the user's first video channel.
+//
+// Deprecated: Use ListChannels and pick the channel from the list, as the
+// first channel isn't necessarily the one to upload to.
+func (c *Client) GetUserChannel(ctx context.Context) (int, error) {
+    channels, err := c.ListChannels(ctx)
+    if err != nil {
+        return 0, err
+    }
+    if len(channels) == 0 {
+        return 0, fmt.Errorf("user has no video channels")
+    }
+    return channels[0].ID, nil
+}
 
-    req, err := http.NewRequest("GET", c.baseURL+"/api/v1/users/me", nil)
+func (c *Client) getUser(ctx context.Context) (*userResponse, error) {
+    token, err := c.accessToken(ctx)
     if err != nil {
-        return 0, fmt.Errorf("creating user request: %w", err)
+        return nil, err
     }
 
-    req.Header.Set("Authorization", "Bearer "+c.token)
+    ctx, cancel := c.requestContext(ctx)
+    defer cancel()
+
+    req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/api/v1/users/me", nil)
+    if err != nil {
+        return nil, fmt.Errorf("creating user request: %w", err)
+    }
+
+    req.Header.Set("Authorization", "Bearer "+token)
 
     resp, err := c.httpClient.Do(req)
     if err != nil {
-        return 0, fmt.Errorf("user request: %w", err)
+        return nil, fmt.Errorf("user request: %w", err)
     }
     defer resp.Body.Close()
 
     if resp.StatusCode != http.StatusOK {
-        body, _ := io.ReadAll(resp.Body)
-        return 0, fmt.Errorf("getting user info failed: %s - %s", resp.Status, string(body))
+        return nil, fmt.Errorf("getting user info failed: %w", newAPIError(resp))
     }
 
     var user userResponse
     if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
-        return 0, fmt.Errorf("decoding user response: %w", err)
-    }
-
-    if len(user.VideoChannels) == 0 {
-        return 0, fmt.Errorf("user has no video channels")
+        return nil, fmt.Errorf("decoding user response: %w", err)
     }
 
-    return user.VideoChannels[0].ID, nil
+    return &user, nil
 }
 
-func (c *Client) Upload(videoPath string, attrs VideoAttributes) (*uploadResponse, error) {
-    if c.token == "" {
-        if err := c.Authenticate(); err != nil {
-            return nil, fmt.Errorf("authentication required: %w", err)
-        }
+func (c *Client) Upload(ctx context.Context, videoPath string, attrs VideoAttributes) (*uploadResponse, error) {
+    token, err := c.accessToken(ctx)
+    if err != nil {
+        return nil, err
     }
 
+    ctx, cancel := c.requestContext(ctx)
+    defer cancel()
+
     file, err := os.Open(videoPath)
     if err != nil {
         return nil, fmt.Errorf("opening video file: %w", err)
     }
     defer file.Close()
 
-    body := &bytes.Buffer{}
-    writer := multipart.NewWriter(body)
-
-    // Add video file
-    part, err := writer.CreateFormFile("videofile", filepath.Base(videoPath))
+    info, err := file.Stat()
     if err != nil {
-        return nil, fmt.Errorf("creating form file: %w", err)
+        return nil, fmt.Errorf("reading video file size: %w", err)
     }
 
-    if _, err := io.Copy(part, file); err != nil {
-        return nil, fmt.Errorf("copying file data: %w", err)
-    }
+    // The multipart body is streamed: metadata fields and the file part
+    // header, then the file itself, then the closing boundary. Only the small
+    // parts are buffered, which also gives an exact Content-Length.
+    body := &bytes.Buffer{}
+    writer := multipart.NewWriter(body)
 
     // Add metadata fields
     fields := map[string]string{
@@ -226,27 +358,61 @@ func (c *Client) Upload(videoPath string, attrs VideoAttributes) (*uploadRespons
         }
     }
 
+    // Add video file
+    if _, err := writer.CreateFormFile("videofile", filepath.Base(videoPath)); err != nil {
+        return nil, fmt.Errorf("creating form file: %w", err)
+    }
+    head := append([]byte(nil), body.Bytes()...)
+
+    body.Reset()
     if err := writer.Close(); err != nil {
         return nil, fmt.Errorf("closing multipart writer: %w", err)
     }
+    tail := append([]byte(nil), body.Bytes()...)
+
+    var fileReader io.Reader = file
+    var throttled *throttledReader
+    if c.limiter != nil {
+        throttled = &throttledReader{r: file, limiter: c.limiter}
+        fileReader = throttled
+    }
+    bodyReader := io.MultiReader(bytes.NewReader(head), fileReader, bytes.NewReader(tail))
 
-    req, err := http.NewRequest("POST", c.baseURL+"/api/v1/videos/upload", body)
+    // Abort the upload when the body stops moving for longer than the idle
+    // timeout, or the server takes longer than the response timeout to
+    // answer once it has the whole file
+    if c.timeouts.Idle > 0 || c.timeouts.Response > 0 {
+        watched, stop := newIdleReader(ctx, bodyReader, c.timeouts.Idle, c.timeouts.Response)
+        defer stop()
+        ctx = watched.ctx
+        bodyReader = watched
+    }
+
+    // Throttling waits end with the request
+    if throttled != nil {
+        throttled.ctx = ctx
+    }
+
+    req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/v1/videos/upload", bodyReader)
     if err != nil {
         return nil, fmt.Errorf("creating upload request: %w", err)
     }
+    req.ContentLength = int64(len(head)) + info.Size() + int64(len(tail))
 
-    req.Header.Set("Authorization", "Bearer "+c.token)
+    req.Header.Set("Authorization", "Bearer "+token)
     req.Header.Set("Content-Type", writer.FormDataContentType())
 
-    resp, err := c.httpClient.Do(req)
+    resp, err := c.uploadClient.Do(req)
     if err != nil {
+        if cause := context.Cause(ctx); cause == ErrStalled || cause == ErrNoResponse {
+            return nil, fmt.Errorf("upload request: %w", cause)
+        }
         return nil, fmt.Errorf("upload request: %w", err)
     }
     defer resp.Body.Close()
 
     if resp.StatusCode != http.StatusOK {
-        body, _ := io.ReadAll(resp.Body)
-        return nil, fmt.Errorf("upload failed: %s - %s", resp.Status, string(body))
+        return nil, fmt.Errorf("upload failed: %w", newAPIError(resp))
     }
 
     var result uploadResponse
@@ -257,23 +423,26 @@ func (c *Client) Upload(videoPath string, attrs VideoAttributes) (*uploadRespons
     return &result, nil
 }
 
-func (c *Client) FetchMetadata() (*Metadata, error) {
+func (c *Client) FetchMetadata(ctx context.Context) (*Metadata, error) {
+    ctx, cancel := c.requestContext(ctx)
+    defer cancel()
+
     metadata := &Metadata{
         Categories: make(map[string]string),
         Licences:   make(map[string]string),
         Privacies:  make(map[string]string),
+        Languages:  make(map[string]string),
     }
 
     // Fetch categories
-    resp, err := c.httpClient.Get(c.baseURL + "/api/v1/videos/categories")
+    resp, err := c.get(ctx, "/api/v1/videos/categories")
     if err != nil {
         return nil, fmt.Errorf("fetching categories: %w", err)
     }
     defer resp.Body.Close()
 
     if resp.StatusCode != http.StatusOK {
-        body, _ := io.ReadAll(resp.Body)
-        return nil, fmt.Errorf("categories request failed: %s - %s", resp.Status, string(body))
+        return nil, fmt.Errorf("categories request failed: %w", newAPIError(resp))
     }
 
     if err := json.NewDecoder(resp.Body).Decode(&metadata.Categories); err != nil {
@@ -281,15 +450,14 @@ func (c *Client) FetchMetadata() (*Metadata, error) {
     }
 
     // Fetch licences
-    resp, err = c.httpClient.Get(c.baseURL + "/api/v1/videos/licences")
+    resp, err = c.get(ctx, "/api/v1/videos/licences")
     if err != nil {
         return nil, fmt.Errorf("fetching licences: %w", err)
     }
     defer resp.Body.Close()
 
     if resp.StatusCode != http.StatusOK {
-        body, _ := io.ReadAll(resp.Body)
-        return nil, fmt.Errorf("licences request failed: %s - %s", resp.Status, string(body))
+        return nil, fmt.Errorf("licences request failed: %w", newAPIError(resp))
     }
 
     if err := json.NewDecoder(resp.Body).Decode(&metadata.Licences); err != nil {
@@ -297,20 +465,43 @@ func (c *Client) FetchMetadata() (*Metadata, error) {
     }
 
     // Fetch privacies
-    resp, err = c.httpClient.Get(c.baseURL + "/api/v1/videos/privacies")
+    resp, err = c.get(ctx, "/api/v1/videos/privacies")
     if err != nil {
         return nil, fmt.Errorf("fetching privacies: %w", err)
     }
     defer resp.Body.Close()
 
     if resp.StatusCode != http.StatusOK {
-        body, _ := io.ReadAll(resp.Body)
-        return nil, fmt.Errorf("privacies request failed: %s - %s", resp.Status, string(body))
+        return nil, fmt.Errorf("privacies request failed: %w", newAPIError(resp))
     }
 
     if err := json.NewDecoder(resp.Body).Decode(&metadata.Privacies); err != nil {
         return nil, fmt.Errorf("decoding privacies: %w", err)
     }
 
+    // Fetch languages
+    resp, err = c.get(ctx, "/api/v1/videos/languages")
+    if err != nil {
+        return nil, fmt.Errorf("fetching languages: %w", err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("languages request failed: %w", newAPIError(resp))
+    }
+
+    if err := json.NewDecoder(resp.Body).Decode(&metadata.Languages); err != nil {
+        return nil, fmt.Errorf("decoding languages: %w", err)
+    }
+
     return metadata, nil
 }
+
+// get performs an unauthenticated GET request
+func (c *Client) get(ctx context.Context, path string) (*http.Response, error) {
+    req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+path, nil)
+    if err != nil {
+        return nil, err
+    }
+    return c.httpClient.Do(req)
+}
@@ -0,0 +1,149 @@
+package peertube
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "strings"
+)
+
+// maxErrorBody limits how much of an error response is kept
+const maxErrorBody = 64 * 1024
+
+// PeerTube error codes (ServerErrorCode) the client cares about
+const (
+    CodeQuotaReached       = "quota_reached"
+    CodeMaxFileSizeReached = "max_file_size_reached"
+    CodeInvalidToken       = "invalid_token"
+    CodeInvalidGrant       = "invalid_grant"
+)
+
+// APIError is a non-2xx response from the PeerTube API. PeerTube answers
+// with RFC 7807 problem JSON; its fields are filled in when present.
+type APIError struct {
+    StatusCode int
+    Status     string
+    Method     string
+    Path       string
+
+    Type   string
+    Title  string
+    Detail string
+    Code   string
+
+    // Raw response body, for servers that don't send problem JSON
+    Body string
+}
+
+type problemResponse struct {
+    Type   string `json:"type"`
+    Title  string `json:"title"`
+    Detail string `json:"detail"`
+    Code   string `json:"code"`
+
+    // Older PeerTube versions use {"error": "..."} instead of problem JSON
+    Error string `json:"error"`
+}
+
+func (e *APIError) Error() string {
+    msg := fmt.Sprintf("%s %s: %s", e.Method, e.Path, e.Status)
+    switch {
+    case e.Detail != "":
+        msg += " - " + e.Detail
+    case e.Title != "":
+        msg += " - " + e.Title
+    case e.Body != "":
+        msg += " - " + e.Body
+    }
+    if e.Code != "" {
+        msg += " (" + e.Code + ")"
+    }
+    return msg
+}
+
+// newAPIError reads the error response and decodes its problem JSON
+func newAPIError(resp *http.Response) *APIError {
+    body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+    apiErr := &APIError{
+        StatusCode: resp.StatusCode,
+        Status:     resp.Status,
+        Body:       strings.TrimSpace(string(body)),
+    }
+    if resp.Request != nil {
+        apiErr.Method = resp.Request.Method
+        apiErr.Path = resp.Request.URL.Path
+    }
+
+    var problem problemResponse
+    if json.Unmarshal(body, &problem) == nil {
+        apiErr.Type = problem.Type
+        apiErr.Title = problem.Title
+        apiErr.Detail = problem.Detail
+        apiErr.Code = problem.Code
+        if apiErr.Detail == "" {
+            apiErr.Detail = problem.Error
+        }
+    }
+
+    return apiErr
+}
+
+// IsAuthError reports whether err is caused by missing or expired credentials
+func IsAuthError(err error) bool {
+    var apiErr *APIError
+    if !errors.As(err, &apiErr) {
+        return false
+    }
+    return apiErr.StatusCode == http.StatusUnauthorized ||
+        apiErr.Code == CodeInvalidToken ||
+        apiErr.Code == CodeInvalidGrant
+}
+
+// IsNetworkError reports whether err means the server could not be reached
+// at all, e.g. the connection was refused or the host name did not resolve.
+// Connections that break off during a request are not included.
+func IsNetworkError(err error) bool {
+    var apiErr *APIError
+    if errors.As(err, &apiErr) {
+        return false
+    }
+    var dnsErr *net.DNSError
+    if errors.As(err, &dnsErr) {
+        return true
+    }
+    var opErr *net.OpError
+    return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// IsQuotaExceeded reports whether err is the server refusing an upload
+// because the user's video quota is used up
+func IsQuotaExceeded(err error) bool {
+    var apiErr *APIError
+    if !errors.As(err, &apiErr) {
+        return false
+    }
+    return apiErr.Code == CodeQuotaReached
+}
+
+// IsRetryable reports whether repeating the request may succeed. Network
+// errors, authentication problems and server-side failures are retryable;
+// client errors such as bad parameters or an oversized file are not.
+func IsRetryable(err error) bool {
+    var apiErr *APIError
+    if !errors.As(err, &apiErr) {
+        return true
+    }
+    if IsAuthError(err) {
+        return true
+    }
+
+    switch apiErr.StatusCode {
+    case http.StatusRequestTimeout, http.StatusTooManyRequests:
+        return true
+    }
+    return apiErr.StatusCode >= 500
+}
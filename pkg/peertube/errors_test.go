@@ -0,0 +1,173 @@
+package peertube
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// response builds an error response to a request for path
+func response(status int, body string) *http.Response {
+    return &http.Response{
+        StatusCode: status,
+        Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+        Body:       io.NopCloser(strings.NewReader(body)),
+        Request:    httptest.NewRequest("POST", "/api/v1/videos/upload", nil),
+    }
+}
+
+func TestNewAPIError(t *testing.T) {
+    tests := []struct {
+        name   string
+        status int
+        body   string
+        want   APIError
+        msg    string
+    }{
+        {
+            "problem json",
+            http.StatusForbidden,
+            `{"type": "about:blank", "title": "Forbidden", "detail": "Quota exceeded", "code": "quota_reached"}`,
+            APIError{Type: "about:blank", Title: "Forbidden", Detail: "Quota exceeded", Code: CodeQuotaReached},
+            "POST /api/v1/videos/upload: 403 Forbidden - Quota exceeded (quota_reached)",
+        },
+        {
+            "title only",
+            http.StatusNotFound,
+            `{"title": "Not Found"}`,
+            APIError{Title: "Not Found"},
+            "POST /api/v1/videos/upload: 404 Not Found - Not Found",
+        },
+        {
+            "legacy error field",
+            http.StatusBadRequest,
+            `{"error": "Invalid client"}`,
+            APIError{Detail: "Invalid client"},
+            "POST /api/v1/videos/upload: 400 Bad Request - Invalid client",
+        },
+        {
+            "detail wins over the legacy field",
+            http.StatusBadRequest,
+            `{"detail": "Bad name", "error": "Invalid"}`,
+            APIError{Detail: "Bad name"},
+            "POST /api/v1/videos/upload: 400 Bad Request - Bad name",
+        },
+        {
+            "not json",
+            http.StatusBadGateway,
+            "  <html>bad gateway</html>\n",
+            APIError{},
+            "POST /api/v1/videos/upload: 502 Bad Gateway - <html>bad gateway</html>",
+        },
+        {
+            "empty",
+            http.StatusInternalServerError,
+            "",
+            APIError{},
+            "POST /api/v1/videos/upload: 500 Internal Server Error",
+        },
+    }
+    for _, tt := range tests {
+        err := newAPIError(response(tt.status, tt.body))
+        if err.StatusCode != tt.status || err.Method != "POST" || err.Path != "/api/v1/videos/upload" {
+            t.Errorf("%s: request details %+v", tt.name, err)
+        }
+        if err.Type != tt.want.Type || err.Title != tt.want.Title || err.Detail != tt.want.Detail || err.Code != tt.want.Code {
+            t.Errorf("%s: got %+v, want %+v", tt.name, err, tt.want)
+        }
+        if err.Error() != tt.msg {
+            t.Errorf("%s: message %q, want %q", tt.name, err.Error(), tt.msg)
+        }
+    }
+}
+
+func TestNewAPIErrorLimitsBody(t *testing.T) {
+    err := newAPIError(response(http.StatusBadGateway, strings.Repeat("x", 2*maxErrorBody)))
+    if len(err.Body) != maxErrorBody {
+        t.Errorf("kept %d bytes of the body, want %d", len(err.Body), maxErrorBody)
+    }
+}
+
+func TestErrorClassification(t *testing.T) {
+    apiErr := func(status int, code string) error {
+        return fmt.Errorf("upload failed: %w", &APIError{StatusCode: status, Code: code})
+    }
+    refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+    reset := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+    noHost := &net.DNSError{Err: "no such host", Name: "peertube.invalid", IsNotFound: true}
+
+    tests := []struct {
+        name                        string
+        err                         error
+        auth, network, quota, retry bool
+    }{
+        {"unauthorized", apiErr(http.StatusUnauthorized, ""), true, false, false, true},
+        {"invalid token", apiErr(http.StatusBadRequest, CodeInvalidToken), true, false, false, true},
+        {"invalid grant", apiErr(http.StatusBadRequest, CodeInvalidGrant), true, false, false, true},
+        {"quota", apiErr(http.StatusForbidden, CodeQuotaReached), false, false, true, false},
+        {"file too big", apiErr(http.StatusRequestEntityTooLarge, CodeMaxFileSizeReached), false, false, false, false},
+        {"bad request", apiErr(http.StatusBadRequest, ""), false, false, false, false},
+        {"request timeout", apiErr(http.StatusRequestTimeout, ""), false, false, false, true},
+        {"too many requests", apiErr(http.StatusTooManyRequests, ""), false, false, false, true},
+        {"server error", apiErr(http.StatusBadGateway, ""), false, false, false, true},
+        {"connection refused", fmt.Errorf("upload request: %w", refused), false, true, false, true},
+        {"unknown host", fmt.Errorf("getting oauth clients: %w", noHost), false, true, false, true},
+        {"connection reset", fmt.Errorf("upload request: %w", reset), false, false, false, true},
+        {"stalled", fmt.Errorf("upload request: %w", ErrStalled), false, false, false, true},
+        {"cancelled", fmt.Errorf("upload request: %w", context.Canceled), false, false, false, true},
+    }
+    for _, tt := range tests {
+        if got := IsAuthError(tt.err); got != tt.auth {
+            t.Errorf("%s: IsAuthError = %v", tt.name, got)
+        }
+        if got := IsNetworkError(tt.err); got != tt.network {
+            t.Errorf("%s: IsNetworkError = %v", tt.name, got)
+        }
+        if got := IsQuotaExceeded(tt.err); got != tt.quota {
+            t.Errorf("%s: IsQuotaExceeded = %v", tt.name, got)
+        }
+        if got := IsRetryable(tt.err); got != tt.retry {
+            t.Errorf("%s: IsRetryable = %v", tt.name, got)
+        }
+    }
+}
+
+func TestClientErrors(t *testing.T) {
+    // A port nothing listens on
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    down := "http://" + ln.Addr().String()
+    ln.Close()
+
+    err = NewClient(down, "user", "secret").Authenticate(context.Background())
+    if !IsNetworkError(err) || !IsRetryable(err) {
+        t.Errorf("unreachable server: %v is not a retryable network error", err)
+    }
+
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/api/v1/oauth-clients/local" {
+            w.Write([]byte(`{"client_id": "id", "client_secret": "secret"}`))
+            return
+        }
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(`{"error": "Invalid grant: user credentials are invalid", "code": "invalid_grant"}`))
+    }))
+    defer srv.Close()
+
+    err = NewClient(srv.URL, "user", "wrong").Authenticate(context.Background())
+    var apiErr *APIError
+    if !errors.As(err, &apiErr) || !IsAuthError(err) || IsNetworkError(err) {
+        t.Fatalf("wrong password: %v is not an auth error", err)
+    }
+    if apiErr.Detail != "Invalid grant: user credentials are invalid" || apiErr.Path != "/api/v1/users/token" {
+        t.Errorf("wrong password: %+v", apiErr)
+    }
+}
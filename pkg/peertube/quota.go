@@ -0,0 +1,117 @@
+package peertube
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+)
+
+// Quota is the user's video storage quota. A limit of -1 means unlimited.
+type Quota struct {
+    Used       int64
+    UsedDaily  int64
+    Limit      int64
+    DailyLimit int64
+}
+
+type quotaUsedResponse struct {
+    VideoQuotaUsed      int64 `json:"videoQuotaUsed"`
+    VideoQuotaUsedDaily int64 `json:"videoQuotaUsedDaily"`
+}
+
+// GetQuota fetches the user's quota and how much of it is used
+func (c *Client) GetQuota(ctx context.Context) (*Quota, error) {
+    user, err := c.getUser(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    token, err := c.accessToken(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    ctx, cancel := c.requestContext(ctx)
+    defer cancel()
+
+    req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/api/v1/users/me/video-quota-used", nil)
+    if err != nil {
+        return nil, fmt.Errorf("creating quota request: %w", err)
+    }
+
+    req.Header.Set("Authorization", "Bearer "+token)
+
+    resp, err := c.httpClient.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("quota request: %w", err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("getting quota failed: %w", newAPIError(resp))
+    }
+
+    var used quotaUsedResponse
+    if err := json.NewDecoder(resp.Body).Decode(&used); err != nil {
+        return nil, fmt.Errorf("decoding quota response: %w", err)
+    }
+
+    return &Quota{
+        Used:       used.VideoQuotaUsed,
+        UsedDaily:  used.VideoQuotaUsedDaily,
+        Limit:      user.VideoQuota,
+        DailyLimit: user.VideoQuotaDaily,
+    }, nil
+}
+
+// Check reports why an upload of size bytes would exceed the quota, or ""
+// if it fits
+func (q *Quota) Check(size int64) string {
+    if q.Limit >= 0 && q.Used+size > q.Limit {
+        return fmt.Sprintf("upload of %s would exceed quota (%s of %s used)",
+            FormatBytes(size), FormatBytes(q.Used), FormatBytes(q.Limit))
+    }
+    if q.DailyLimit >= 0 && q.UsedDaily+size > q.DailyLimit {
+        return fmt.Sprintf("upload of %s would exceed daily quota (%s of %s used)",
+            FormatBytes(size), FormatBytes(q.UsedDaily), FormatBytes(q.DailyLimit))
+    }
+    return ""
+}
+
+// TooLarge reports why a file of size bytes can never be uploaded, even
+// with all of the quota free, or "" if it could fit
+func (q *Quota) TooLarge(size int64) string {
+    if q.Limit >= 0 && size > q.Limit {
+        return fmt.Sprintf("file of %s is larger than the quota of %s", FormatBytes(size), FormatBytes(q.Limit))
+    }
+    if q.DailyLimit >= 0 && size > q.DailyLimit {
+        return fmt.Sprintf("file of %s is larger than the daily quota of %s", FormatBytes(size), FormatBytes(q.DailyLimit))
+    }
+    return ""
+}
+
+func (q *Quota) String() string {
+    return fmt.Sprintf("%s, daily %s", formatQuota(q.Used, q.Limit), formatQuota(q.UsedDaily, q.DailyLimit))
+}
+
+func formatQuota(used, limit int64) string {
+    if limit < 0 {
+        return fmt.Sprintf("%s used (unlimited)", FormatBytes(used))
+    }
+    return fmt.Sprintf("%s of %s used", FormatBytes(used), FormatBytes(limit))
+}
+
+// FormatBytes formats a size in bytes with binary units, e.g. "1.5 GiB"
+func FormatBytes(n int64) string {
+    const unit = 1024
+    if n < unit {
+        return fmt.Sprintf("%d B", n)
+    }
+    div, exp := int64(unit), 0
+    for m := n / unit; m >= unit; m /= unit {
+        div *= unit
+        exp++
+    }
+    return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
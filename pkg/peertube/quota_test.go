@@ -0,0 +1,18 @@
+package peertube
+
+import "testing"
+
+func TestFormatBytes(t *testing.T) {
+    for n, want := range map[int64]string{
+        0:               "0 B",
+        1023:            "1023 B",
+        1024:            "1.0 KiB",
+        1536:            "1.5 KiB",
+        5 * 1024 * 1024: "5.0 MiB",
+        3 << 30:         "3.0 GiB",
+    } {
+        if got := FormatBytes(n); got != want {
+            t.Errorf("FormatBytes(%d) = %q, want %q", n, got, want)
+        }
+    }
+}
@@ -0,0 +1,161 @@
+package peertube
+
+import (
+    "context"
+    "errors"
+    "io"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// minBurst keeps reads reasonably sized at very low rates
+const minBurst = 32 * 1024
+
+// rateLimiter is a token bucket refilled at rate bytes per second. It is
+// shared by all uploads of a client so the limit applies to the total.
+type rateLimiter struct {
+    mu     sync.Mutex
+    rate   float64
+    burst  float64
+    tokens float64
+    last   time.Time
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+    burst := float64(bytesPerSecond)
+    if burst < minBurst {
+        burst = minBurst
+    }
+    return &rateLimiter{
+        rate:   float64(bytesPerSecond),
+        burst:  burst,
+        tokens: burst,
+        last:   time.Now(),
+    }
+}
+
+// take consumes n tokens and returns how long the caller must wait before
+// the bucket is back in credit
+func (l *rateLimiter) take(n int) time.Duration {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    now := time.Now()
+    l.tokens += now.Sub(l.last).Seconds() * l.rate
+    if l.tokens > l.burst {
+        l.tokens = l.burst
+    }
+    l.last = now
+
+    l.tokens -= float64(n)
+    if l.tokens >= 0 {
+        return 0
+    }
+    return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// throttledReader limits how fast the wrapped reader can be consumed.
+// Waiting for the limiter stops as soon as ctx is done.
+type throttledReader struct {
+    r       io.Reader
+    limiter *rateLimiter
+    ctx     context.Context
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+    if len(p) > int(t.limiter.burst) {
+        p = p[:int(t.limiter.burst)]
+    }
+
+    n, err := t.r.Read(p)
+    if n > 0 {
+        if wait := t.limiter.take(n); wait > 0 {
+            timer := time.NewTimer(wait)
+            defer timer.Stop()
+            select {
+            case <-timer.C:
+            case <-t.ctx.Done():
+                return n, t.ctx.Err()
+            }
+        }
+    }
+    return n, err
+}
+
+// ErrStalled is the cause of an upload cancelled by the idle timeout
+var ErrStalled = errors.New("upload stalled: no data sent within idle timeout")
+
+// ErrNoResponse is the cause of an upload cancelled by the response timeout
+var ErrNoResponse = errors.New("upload sent but no response within response timeout")
+
+// idleReader cancels its context when no data is read for the idle timeout,
+// and once everything is read, when the response takes longer than the
+// response timeout. A zero timeout disables that limit.
+type idleReader struct {
+    r        io.Reader
+    ctx      context.Context
+    idle     time.Duration
+    response time.Duration
+    expire   func()
+    sent     atomic.Bool
+
+    mu    sync.Mutex
+    timer *time.Timer
+}
+
+// newIdleReader wraps r and returns a context derived from ctx that is
+// cancelled with ErrStalled when reads stall, or with ErrNoResponse when
+// the response is late. Call stop when done.
+func newIdleReader(ctx context.Context, r io.Reader, idle, response time.Duration) (*idleReader, func()) {
+    ctx, cancel := context.WithCancelCause(ctx)
+    ir := &idleReader{
+        r:        r,
+        ctx:      ctx,
+        idle:     idle,
+        response: response,
+    }
+    ir.expire = func() {
+        if ir.sent.Load() {
+            cancel(ErrNoResponse)
+        } else {
+            cancel(ErrStalled)
+        }
+    }
+    ir.arm(idle)
+
+    stop := func() {
+        ir.arm(0)
+        cancel(nil)
+    }
+    return ir, stop
+}
+
+func (i *idleReader) Read(p []byte) (int, error) {
+    n, err := i.r.Read(p)
+    if n > 0 && !i.sent.Load() {
+        i.arm(i.idle)
+    }
+    if err == io.EOF && !i.sent.Swap(true) {
+        // The body is sent; from now on only the response timeout applies
+        i.arm(i.response)
+    }
+    return n, err
+}
+
+// arm restarts the timer to expire after d, or stops it if d is zero
+func (i *idleReader) arm(d time.Duration) {
+    i.mu.Lock()
+    defer i.mu.Unlock()
+
+    switch {
+    case d <= 0:
+        if i.timer != nil {
+            i.timer.Stop()
+        }
+    case i.timer == nil:
+        i.timer = time.AfterFunc(d, i.expire)
+    default:
+        i.timer.Reset(d)
+    }
+}
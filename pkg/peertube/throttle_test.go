@@ -0,0 +1,178 @@
+package peertube
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestThrottledReaderLimitsRate(t *testing.T) {
+    data := make([]byte, 3*minBurst)
+    r := &throttledReader{
+        r:       bytes.NewReader(data),
+        limiter: newRateLimiter(2 * minBurst),
+        ctx:     context.Background(),
+    }
+
+    // The first burst is free; the rest takes about half a second
+    start := time.Now()
+    n, err := io.Copy(io.Discard, r)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n != int64(len(data)) {
+        t.Fatalf("read %d bytes, want %d", n, len(data))
+    }
+    if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+        t.Errorf("read %d bytes in %s, faster than the limit", n, elapsed)
+    }
+}
+
+func TestThrottledReaderStopsOnCancel(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    r := &throttledReader{
+        r:       bytes.NewReader(make([]byte, 2*minBurst)),
+        limiter: newRateLimiter(1),
+        ctx:     ctx,
+    }
+
+    // The second read would wait hours for the limiter
+    time.AfterFunc(50*time.Millisecond, cancel)
+    start := time.Now()
+    _, err := io.Copy(io.Discard, r)
+    if !errors.Is(err, context.Canceled) {
+        t.Fatalf("err = %v, want context.Canceled", err)
+    }
+    if elapsed := time.Since(start); elapsed > 5*time.Second {
+        t.Errorf("cancelled read returned after %s", elapsed)
+    }
+}
+
+// uploadServer is a PeerTube server that accepts any login and hands
+// uploads to upload
+func uploadServer(t *testing.T, upload http.HandlerFunc) *httptest.Server {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/api/v1/oauth-clients/local", func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(`{"client_id": "id", "client_secret": "secret"}`))
+    })
+    mux.HandleFunc("/api/v1/users/token", func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(`{"access_token": "token"}`))
+    })
+    mux.HandleFunc("/api/v1/videos/upload", upload)
+
+    srv := httptest.NewServer(mux)
+    t.Cleanup(srv.Close)
+    return srv
+}
+
+// slowUpload reads the whole upload, then answers after delay
+func slowUpload(delay time.Duration) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        io.Copy(io.Discard, r.Body)
+        time.Sleep(delay)
+        w.Write([]byte(`{"video": {"id": 1, "uuid": "abc"}}`))
+    }
+}
+
+// videoFile writes a file of size bytes
+func videoFile(t *testing.T, size int) string {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "video.mp4")
+    if err := os.WriteFile(path, bytes.Repeat([]byte("v"), size), 0644); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+func TestUploadThrottled(t *testing.T) {
+    var got []byte
+    srv := uploadServer(t, func(w http.ResponseWriter, r *http.Request) {
+        file, _, err := r.FormFile("videofile")
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        got, _ = io.ReadAll(file)
+        w.Write([]byte(`{"video": {"id": 1, "uuid": "abc"}}`))
+    })
+
+    c := NewClient(srv.URL, "user", "secret")
+    c.SetUploadRateLimit(2 * minBurst)
+
+    // The first burst is free; the rest takes about half a second
+    start := time.Now()
+    result, err := c.Upload(context.Background(), videoFile(t, 3*minBurst), VideoAttributes{Name: "Video"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+        t.Errorf("uploaded in %s, faster than the limit", elapsed)
+    }
+    if len(got) != 3*minBurst || result.Video.UUID != "abc" {
+        t.Errorf("server got %d bytes, result %+v", len(got), result)
+    }
+}
+
+func TestUploadThrottledStopsOnCancel(t *testing.T) {
+    srv := uploadServer(t, slowUpload(0))
+
+    c := NewClient(srv.URL, "user", "secret")
+    c.SetUploadRateLimit(1)
+
+    // The file would take hours at this rate
+    ctx, cancel := context.WithCancel(context.Background())
+    time.AfterFunc(100*time.Millisecond, cancel)
+    start := time.Now()
+    _, err := c.Upload(ctx, videoFile(t, 2*minBurst), VideoAttributes{})
+    if !errors.Is(err, context.Canceled) {
+        t.Fatalf("err = %v, want context.Canceled", err)
+    }
+    if elapsed := time.Since(start); elapsed > 5*time.Second {
+        t.Errorf("cancelled upload returned after %s", elapsed)
+    }
+}
+
+func TestUploadResponseOutlastsIdleTimeout(t *testing.T) {
+    srv := uploadServer(t, slowUpload(300*time.Millisecond))
+
+    c := NewClient(srv.URL, "user", "secret")
+    c.SetTimeouts(Timeouts{Connect: time.Second, Idle: 100 * time.Millisecond})
+    if _, err := c.Upload(context.Background(), videoFile(t, 1024), VideoAttributes{}); err != nil {
+        t.Fatalf("slow response after a complete upload: %v", err)
+    }
+}
+
+func TestUploadResponseTimeout(t *testing.T) {
+    srv := uploadServer(t, slowUpload(time.Second))
+
+    c := NewClient(srv.URL, "user", "secret")
+    c.SetTimeouts(Timeouts{Connect: time.Second, Idle: time.Second, Response: 100 * time.Millisecond})
+    _, err := c.Upload(context.Background(), videoFile(t, 1024), VideoAttributes{})
+    if !errors.Is(err, ErrNoResponse) {
+        t.Fatalf("err = %v, want ErrNoResponse", err)
+    }
+}
+
+func TestUploadIdleTimeout(t *testing.T) {
+    // The server never reads the body, so sending stalls once the socket
+    // buffers are full
+    release := make(chan struct{})
+    srv := uploadServer(t, func(w http.ResponseWriter, r *http.Request) {
+        <-release
+    })
+    t.Cleanup(func() { close(release) })
+
+    c := NewClient(srv.URL, "user", "secret")
+    c.SetTimeouts(Timeouts{Connect: time.Second, Idle: 200 * time.Millisecond})
+    _, err := c.Upload(context.Background(), videoFile(t, 64<<20), VideoAttributes{})
+    if !errors.Is(err, ErrStalled) {
+        t.Fatalf("err = %v, want ErrStalled", err)
+    }
+}
@@ -0,0 +1,63 @@
+package watcher
+
+import (
+    "fmt"
+    "time"
+
+    "github.com/fsnotify/fsnotify"
+)
+
+// Backend selects how changes in the watch folder are detected
+type Backend struct {
+    Kind         string        // "fsnotify", "poll" or "auto"
+    PollInterval time.Duration // time between scans when polling
+}
+
+// backend delivers create, write and remove events for the files directly
+// inside the watched folders
+type backend interface {
+    Add(path string) error
+    Remove(path string) error
+    Events() <-chan fsnotify.Event
+    Errors() <-chan error
+    Close() error
+}
+
+// resolveKind returns the backend to use for path. With "auto", folders on
+// a network filesystem are polled, since change notifications from SMB and
+// NFS servers are unreliable.
+func resolveKind(kind, path string) (string, error) {
+    if kind != "auto" {
+        return kind, nil
+    }
+    remote, err := isNetworkFS(path)
+    if err != nil {
+        return "", fmt.Errorf("detecting filesystem of %s: %w", path, err)
+    }
+    if remote {
+        return "poll", nil
+    }
+    return "fsnotify", nil
+}
+
+func newBackend(kind string, interval time.Duration) (backend, error) {
+    switch kind {
+    case "poll":
+        return newPollBackend(interval), nil
+    case "fsnotify":
+        w, err := fsnotify.NewWatcher()
+        if err != nil {
+            return nil, fmt.Errorf("creating fsnotify watcher: %w", err)
+        }
+        return fsnotifyBackend{w}, nil
+    }
+    return nil, fmt.Errorf("unknown watcher backend %q", kind)
+}
+
+// fsnotifyBackend uses the operating system's change notifications
+type fsnotifyBackend struct {
+    *fsnotify.Watcher
+}
+
+func (b fsnotifyBackend) Events() <-chan fsnotify.Event { return b.Watcher.Events }
+func (b fsnotifyBackend) Errors() <-chan error          { return b.Watcher.Errors }
@@ -0,0 +1,124 @@
+package watcher
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// Filter selects which files in the watch folder are uploaded, beyond the
+// video extensions
+type Filter struct {
+    Include      []string // glob patterns for file names, used instead of the extensions when set
+    Exclude      []string // glob patterns for file names to leave alone
+    MinSize      int64    // bytes (0 = no limit)
+    MaxSize      int64    // bytes (0 = no limit)
+    SniffContent bool     // check that the first bytes look like a video
+}
+
+// SetFilter replaces the file filter. Files already queued are checked
+// against it when they settle.
+func (w *Watcher) SetFilter(f Filter) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    w.filter = f
+}
+
+// MatchName reports whether a file name is selected by the include patterns,
+// or by the extensions if there are none, and not excluded. reason is set
+// when the name would have been selected but is excluded.
+func (f Filter) MatchName(name string, extensions []string) (ok bool, reason string) {
+    name = strings.ToLower(filepath.Base(name))
+    ext := filepath.Ext(name)
+    for _, tempExt := range temporaryExtensions {
+        if ext == tempExt {
+            return false, ""
+        }
+    }
+
+    if len(f.Include) > 0 {
+        ok = matchAny(f.Include, name) != ""
+    } else {
+        for _, validExt := range extensions {
+            if ext == strings.ToLower(validExt) {
+                ok = true
+                break
+            }
+        }
+    }
+    if !ok {
+        return false, ""
+    }
+
+    if pattern := matchAny(f.Exclude, name); pattern != "" {
+        return false, fmt.Sprintf("matches exclude pattern %q", pattern)
+    }
+    return true, ""
+}
+
+// check returns why a settled file is rejected by its size or content, or
+// "" if it is accepted
+func (f Filter) check(path string, info os.FileInfo) (string, error) {
+    if f.MinSize > 0 && info.Size() < f.MinSize {
+        return fmt.Sprintf("smaller than the minimum size (%d < %d bytes)", info.Size(), f.MinSize), nil
+    }
+    if f.MaxSize > 0 && info.Size() > f.MaxSize {
+        return fmt.Sprintf("larger than the maximum size (%d > %d bytes)", info.Size(), f.MaxSize), nil
+    }
+
+    if f.SniffContent {
+        file, err := os.Open(path)
+        if err != nil {
+            return "", err
+        }
+        defer file.Close()
+
+        head := make([]byte, 512)
+        n, err := io.ReadFull(file, head)
+        if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+            return "", err
+        }
+        if !looksLikeVideo(head[:n]) {
+            return fmt.Sprintf("content does not look like a video (detected %s)", http.DetectContentType(head[:n])), nil
+        }
+    }
+    return "", nil
+}
+
+// matchAny returns the first pattern that matches name
+func matchAny(patterns []string, name string) string {
+    for _, pattern := range patterns {
+        if ok, _ := filepath.Match(strings.ToLower(pattern), name); ok {
+            return pattern
+        }
+    }
+    return ""
+}
+
+// looksLikeVideo recognises the containers of common video formats by
+// their first bytes
+func looksLikeVideo(head []byte) bool {
+    switch {
+    case len(head) >= 8 && string(head[4:8]) == "ftyp": // MP4, MOV, M4V, 3GP
+        return true
+    case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}): // Matroska, WebM
+        return true
+    case len(head) >= 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "AVI ":
+        return true
+    case bytes.HasPrefix(head, []byte("FLV")):
+        return true
+    case bytes.HasPrefix(head, []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11}): // ASF, WMV
+        return true
+    case bytes.HasPrefix(head, []byte{0x00, 0x00, 0x01, 0xBA}): // MPEG program stream
+        return true
+    case len(head) > 188 && head[0] == 0x47 && head[188] == 0x47: // MPEG transport stream
+        return true
+    case bytes.HasPrefix(head, []byte("OggS")):
+        return true
+    }
+    return strings.HasPrefix(http.DetectContentType(head), "video/")
+}
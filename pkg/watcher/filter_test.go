@@ -0,0 +1,111 @@
+package watcher
+
+import (
+    "bytes"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestFilterMatchName(t *testing.T) {
+    extensions := []string{".mp4", ".MKV"}
+    tests := []struct {
+        name   string
+        filter Filter
+        file   string
+        ok     bool
+        reason string
+    }{
+        {"extension", Filter{}, "video.mp4", true, ""},
+        {"extension in any case", Filter{}, "VIDEO.Mkv", true, ""},
+        {"other extension", Filter{}, "notes.txt", false, ""},
+        {"path is ignored", Filter{}, filepath.Join("upload.mp4", "notes.txt"), false, ""},
+        {"temporary file", Filter{Include: []string{"*"}}, "video.mp4.part", false, ""},
+        {"include replaces extensions", Filter{Include: []string{"cam?_*.mov"}}, "cam1_take2.mov", true, ""},
+        {"not included", Filter{Include: []string{"cam?_*.mov"}}, "video.mp4", false, ""},
+        {"include in any case", Filter{Include: []string{"CAM*"}}, "cam1.mov", true, ""},
+        {"excluded", Filter{Exclude: []string{"*_proxy.mp4"}}, "clip_proxy.mp4", false, `matches exclude pattern "*_proxy.mp4"`},
+        {"exclude in any case", Filter{Exclude: []string{"*_PROXY.*"}}, "Clip_Proxy.mkv", false, `matches exclude pattern "*_PROXY.*"`},
+        {"exclude only applies to selected files", Filter{Exclude: []string{"*.txt"}}, "notes.txt", false, ""},
+        {"not excluded", Filter{Exclude: []string{"*_proxy.mp4"}}, "clip.mp4", true, ""},
+    }
+    for _, tt := range tests {
+        ok, reason := tt.filter.MatchName(tt.file, extensions)
+        if ok != tt.ok || reason != tt.reason {
+            t.Errorf("%s: MatchName(%q) = %v, %q; want %v, %q", tt.name, tt.file, ok, reason, tt.ok, tt.reason)
+        }
+    }
+}
+
+func TestFilterCheck(t *testing.T) {
+    dir := t.TempDir()
+    mp4 := filepath.Join(dir, "video.mp4")
+    if err := os.WriteFile(mp4, append([]byte("\x00\x00\x00\x18ftypisom"), make([]byte, 100)...), 0644); err != nil {
+        t.Fatal(err)
+    }
+    text := filepath.Join(dir, "renamed.mp4")
+    if err := os.WriteFile(text, []byte(strings.Repeat("not a video\n", 10)), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name   string
+        filter Filter
+        path   string
+        reason string // prefix, "" = accepted
+    }{
+        {"no limits", Filter{}, text, ""},
+        {"too small", Filter{MinSize: 1000}, mp4, "smaller than the minimum size (112 < 1000 bytes)"},
+        {"too large", Filter{MaxSize: 100}, mp4, "larger than the maximum size (112 > 100 bytes)"},
+        {"within limits", Filter{MinSize: 100, MaxSize: 200}, mp4, ""},
+        {"video content", Filter{SniffContent: true}, mp4, ""},
+        {"other content", Filter{SniffContent: true}, text, "content does not look like a video (detected text/plain"},
+    }
+    for _, tt := range tests {
+        info, err := os.Stat(tt.path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        reason, err := tt.filter.check(tt.path, info)
+        if err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+            continue
+        }
+        if tt.reason == "" && reason != "" || !strings.HasPrefix(reason, tt.reason) {
+            t.Errorf("%s: reason = %q, want %q", tt.name, reason, tt.reason)
+        }
+    }
+}
+
+func TestLooksLikeVideo(t *testing.T) {
+    ts := make([]byte, 376)
+    ts[0], ts[188] = 0x47, 0x47
+
+    tests := []struct {
+        name string
+        head []byte
+        want bool
+    }{
+        {"mp4", []byte("\x00\x00\x00\x20ftypmp42"), true},
+        {"quicktime", []byte("\x00\x00\x00\x14ftypqt  "), true},
+        {"matroska", []byte{0x1A, 0x45, 0xDF, 0xA3, 0x01}, true},
+        {"avi", []byte("RIFF\x00\x00\x00\x00AVI LIST"), true},
+        {"flv", []byte("FLV\x01\x05"), true},
+        {"asf", []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11, 0xA6}, true},
+        {"mpeg program stream", []byte{0x00, 0x00, 0x01, 0xBA, 0x44}, true},
+        {"mpeg transport stream", ts, true},
+        {"ogg", []byte("OggS\x00\x02"), true},
+        {"wav", []byte("RIFF\x00\x00\x00\x00WAVEfmt "), false},
+        {"transport stream cut short", ts[:188], false},
+        {"text", []byte("just some text"), false},
+        {"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0}, false},
+        {"zeros", bytes.Repeat([]byte{0}, 512), false},
+        {"empty", nil, false},
+    }
+    for _, tt := range tests {
+        if got := looksLikeVideo(tt.head); got != tt.want {
+            t.Errorf("%s: looksLikeVideo = %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
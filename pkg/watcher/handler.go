@@ -1,154 +1,690 @@
 package watcher
 
 import (
+    "context"
+    "errors"
     "fmt"
     "log"
     "os"
     "path/filepath"
     "strings"
+    "sync"
+    "sync/atomic"
+    "time"
 
     "github.com/dsu-teknik/peertube-monitor/pkg/config"
+    "github.com/dsu-teknik/peertube-monitor/pkg/notify"
     "github.com/dsu-teknik/peertube-monitor/pkg/peertube"
 )
 
+const (
+    // quotaRetryInterval is how long a file waits before the quota is checked again
+    quotaRetryInterval = 15 * time.Minute
+
+    // pausedRetryInterval is how often a held file is tried again while the
+    // handler is paused, in case Resume isn't followed by RetryHeld
+    pausedRetryInterval = time.Minute
+
+    // channelRetryInterval is how long a file waits when the channel setting
+    // isn't one of the user's channels; a fixed configuration retries sooner
+    channelRetryInterval = 15 * time.Minute
+
+    // Failed uploads are retried after retryBaseDelay, doubling each attempt
+    retryBaseDelay = 30 * time.Second
+    retryMaxDelay  = 10 * time.Minute
+)
+
+// Result statuses
+const (
+    StatusUploaded = "uploaded"
+    StatusFailed   = "failed"
+    StatusSkipped  = "skipped"
+    StatusDryRun   = "dry-run"
+)
+
+// Result describes what happened to a file
+type Result struct {
+    File       string
+    Status     string
+    Attributes peertube.VideoAttributes
+    UUID       string
+    URL        string
+    Dest       string // where the file was (or would be) moved ("" when deleted)
+    Error      string
+}
+
 type UploadHandler struct {
-    client     *peertube.Client
-    config     *config.Config
+    client     atomic.Pointer[peertube.Client]
+    config     atomic.Pointer[config.Config]
+    notifier   notify.Notifier
     logger     *log.Logger
+
+    mu         sync.Mutex
     retryCount map[string]int
+
+    // channel caches the channel looked up for channelConfig
+    channel       int
+    channelConfig *config.Config
+
+    // paused is why files are held instead of uploaded, if they are
+    paused string
+    // pausedHolds counts the files held because uploads were paused
+    pausedHolds int
+    // onServerDown is told when an upload finds the server unreachable or
+    // the credentials rejected, to pause uploads until it is ready again
+    onServerDown func(reason string)
+
+    // keepFiles leaves files where they are after an upload or failure
+    keepFiles bool
+    // dryRun logs what would be uploaded instead of uploading, and touches
+    // no files
+    dryRun bool
 }
 
-func NewUploadHandler(client *peertube.Client, cfg *config.Config, logger *log.Logger) *UploadHandler {
-    return &UploadHandler{
-        client:     client,
-        config:     cfg,
+func NewUploadHandler(client *peertube.Client, cfg *config.Config, notifier notify.Notifier, logger *log.Logger) *UploadHandler {
+    h := &UploadHandler{
+        notifier:   notifier,
         logger:     logger,
         retryCount: make(map[string]int),
     }
+    h.client.Store(client)
+    h.config.Store(cfg)
+    return h
+}
+
+// Reconfigure swaps in new settings and client. Uploads already running
+// finish with the settings they started with.
+func (h *UploadHandler) Reconfigure(cfg *config.Config, client *peertube.Client) {
+    h.config.Store(cfg)
+    h.client.Store(client)
 }
 
-func (h *UploadHandler) HandleFile(path string) error {
+// Pause holds every file with reason until Resume is called, e.g. while
+// the server can't be reached
+func (h *UploadHandler) Pause(reason string) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    h.paused = reason
+}
+
+// Resume lets held files be uploaded again
+func (h *UploadHandler) Resume() {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    h.paused = ""
+}
+
+// OnServerDown sets what to call when an upload finds the server
+// unreachable or the credentials rejected. It is expected to Pause the
+// handler and Resume it once the server is ready. Without it the file is
+// only held.
+func (h *UploadHandler) OnServerDown(fn func(reason string)) {
+    h.onServerDown = fn
+}
+
+// PausedHolds returns how many times a file was held because uploads were
+// paused, so a batch run can tell that the server could not be used
+func (h *UploadHandler) PausedHolds() int {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    return h.pausedHolds
+}
+
+// holdPaused holds a file while the server can't be used
+func (h *UploadHandler) holdPaused(reason string) error {
+    h.mu.Lock()
+    h.pausedHolds++
+    h.mu.Unlock()
+    return &DeferError{Reason: reason, RetryAfter: pausedRetryInterval}
+}
+
+// SetKeepFiles leaves files in place instead of moving them to the done or
+// failed folder, for files uploaded by hand
+func (h *UploadHandler) SetKeepFiles(keep bool) {
+    h.keepFiles = keep
+}
+
+// SetDryRun makes the handler resolve and log everything it would upload,
+// without uploading, running hooks or moving files
+func (h *UploadHandler) SetDryRun(dryRun bool) {
+    h.dryRun = dryRun
+}
+
+// HandleFile runs the hooks and validation for one file, uploads it and
+// moves it to the done or failed folder. A file that is given up on is
+// reported in the Result with StatusFailed; a *DeferError means it should be
+// tried again later.
+func (h *UploadHandler) HandleFile(ctx context.Context, path string) (*Result, error) {
+    // Use one snapshot of the settings for the whole upload, even if the
+    // configuration is reloaded meanwhile
+    cfg := h.config.Load()
+    client := h.client.Load()
+
+    // While paused the file waits in the queue
+    h.mu.Lock()
+    paused := h.paused
+    h.mu.Unlock()
+    if paused != "" {
+        if !h.dryRun {
+            return nil, h.holdPaused(paused)
+        }
+        h.logger.Printf("Dry run: would hold the file: %s", paused)
+    }
+    h.logger.Printf("Processing file: %s", path)
+
+    // Outside the upload windows the file waits in the queue
+    windows, err := cfg.Watcher.ParseUploadWindows()
+    if err != nil {
+        return nil, err
+    }
+    if wait := config.UntilNextWindow(windows, time.Now()); wait > 0 {
+        if !h.dryRun {
+            return nil, &DeferError{Reason: "outside upload window", RetryAfter: wait}
+        }
+        h.logger.Printf("Dry run: outside upload window, would wait %s", wait.Round(time.Second))
+    }
+
     h.logger.Printf("Starting upload: %s", path)
 
-    // Extract video name from filename (without extension)
-    filename := filepath.Base(path)
-    videoName := strings.TrimSuffix(filename, filepath.Ext(filename))
+    // Run pre-upload hook, which may replace the file or override metadata
+    uploadPath := path
+    var meta *hookMetadata
+    if hook := cfg.Watcher.Hooks.PreUpload; hookEnabled(hook) && h.dryRun {
+        h.logger.Printf("Dry run: would run pre-upload hook %q", hook.Command)
+    } else if hookEnabled(hook) {
+        // The hook's folder, and a replacement file written to it, only
+        // last for this attempt; the hook runs again if the file is retried
+        dir, err := newHookDir()
+        if err != nil {
+            return h.failed(path, err, h.handleFailure(path, err))
+        }
+        defer h.removeHookDir(dir)
+
+        out, err := h.runHook(ctx, hook, hookInput{Event: hookPreUpload, File: path, TmpDir: dir})
+        if err == nil {
+            meta, err = parsePreHookOutput(path, dir, cfg.Watcher.WatchPath, out)
+        }
+        if ctx.Err() != nil {
+            return nil, h.interrupted(ctx, path)
+        }
+        if err != nil {
+            switch hook.OnError {
+            case "skip":
+                h.logger.Printf("Skipping file: %v", err)
+                return &Result{File: path, Status: StatusSkipped, Error: err.Error()}, nil
+            case "ignore":
+                h.logger.Printf("WARNING: Ignoring pre-upload hook error: %v", err)
+                meta = nil
+            default:
+                h.logger.Printf("ERROR: Upload failed: %v", err)
+                return h.failed(path, err, h.moveToFailed(path, err, h.attempts(path)+1))
+            }
+        }
+        if meta != nil && meta.Path != "" && meta.Path != path {
+            uploadPath = meta.Path
+            h.logger.Printf("Pre-upload hook replaced file with: %s", uploadPath)
+        }
+    }
 
-    // Get channel ID from config or fetch from API
-    channelID := h.config.PeerTube.Defaults.ChannelID
-    if channelID == 0 {
+    // Check the file is a playable video before spending time uploading it
+    var media *MediaInfo
+    if cfg.Watcher.Validation.Enabled {
         var err error
-        channelID, err = h.client.GetUserChannel()
+        media, err = probeFile(ctx, cfg.Watcher.Validation, uploadPath)
+        if ctx.Err() != nil {
+            return nil, h.interrupted(ctx, path)
+        }
+        var rejectErr *RejectError
+        if errors.As(err, &rejectErr) {
+            h.logger.Printf("ERROR: Validation failed: %s", rejectErr.Reason)
+            return h.failed(path, err, h.moveToFailed(path, err, h.attempts(path)+1))
+        }
         if err != nil {
-            return fmt.Errorf("getting user channel: %w", err)
+            return h.failed(path, err, h.handleFailure(path, err))
+        }
+        h.logger.Printf("Validated: %s, %dx%d %s, %s", media.Container, media.Width, media.Height,
+            media.VideoCodec, media.Duration.Round(time.Second))
+    }
+
+    // Render title and description from the file details
+    data := newTemplateData(path, media)
+    nameTemplate := cfg.PeerTube.Defaults.Name
+    if nameTemplate == "" {
+        nameTemplate = defaultNameTemplate
+    }
+    videoName, err := renderTemplate("name", nameTemplate, data)
+    if err != nil {
+        return h.failed(path, err, h.moveToFailed(path, err, h.attempts(path)+1))
+    }
+    videoName = strings.TrimSpace(videoName)
+    description, err := renderTemplate("description", cfg.PeerTube.Defaults.Description, data)
+    if err != nil {
+        return h.failed(path, err, h.moveToFailed(path, err, h.attempts(path)+1))
+    }
+
+    // The channel is resolved at startup; check it once per configuration
+    channelID, err := h.channelID(ctx, cfg, client)
+    if ctx.Err() != nil {
+        return nil, h.interrupted(ctx, path)
+    }
+    var deferErr *DeferError
+    if errors.As(err, &deferErr) {
+        if !h.dryRun {
+            return nil, err
+        }
+        h.logger.Printf("Dry run: would hold the file: %s", deferErr.Reason)
+    } else if err != nil {
+        err = fmt.Errorf("getting user channel: %w", err)
+        return h.failed(path, err, h.handleUploadError(path, err))
+    }
+
+    // Hold the file while the upload would exceed the user's quota
+    if err := h.checkQuota(ctx, uploadPath); err != nil {
+        var rejectErr *RejectError
+        if errors.As(err, &rejectErr) {
+            h.logger.Printf("ERROR: Upload failed: %s", rejectErr.Reason)
+            return h.failed(path, err, h.moveToFailed(path, err, h.attempts(path)+1))
         }
-        h.logger.Printf("Using default channel ID: %d", channelID)
+        return nil, err
     }
 
     // Build video attributes from config defaults
     attrs := peertube.VideoAttributes{
         ChannelID:       channelID,
         Name:            videoName,
-        Category:        h.config.PeerTube.Defaults.Category,
-        Licence:         h.config.PeerTube.Defaults.Licence,
-        Language:        h.config.PeerTube.Defaults.Language,
-        Privacy:         h.config.PeerTube.Defaults.Privacy,
-        Description:     h.config.PeerTube.Defaults.Description,
-        Tags:            h.config.PeerTube.Defaults.Tags,
-        DownloadEnabled: h.config.PeerTube.Defaults.DownloadEnabled,
-        CommentsEnabled: h.config.PeerTube.Defaults.CommentsEnabled,
-        WaitTranscoding: h.config.PeerTube.Defaults.WaitTranscoding,
-        NSFW:            h.config.PeerTube.Defaults.NSFW,
+        Category:        cfg.PeerTube.Defaults.Category,
+        Licence:         cfg.PeerTube.Defaults.Licence,
+        Language:        cfg.PeerTube.Defaults.Language,
+        Privacy:         cfg.PeerTube.Defaults.Privacy,
+        Description:     description,
+        Tags:            cfg.PeerTube.Defaults.Tags,
+        DownloadEnabled: cfg.PeerTube.Defaults.DownloadEnabled,
+        CommentsEnabled: cfg.PeerTube.Defaults.CommentsEnabled,
+        WaitTranscoding: cfg.PeerTube.Defaults.WaitTranscoding,
+        NSFW:            cfg.PeerTube.Defaults.NSFW,
+    }
+    if err := meta.apply(&attrs, cfg.PeerTube.Defaults.Languages); err != nil {
+        h.logger.Printf("ERROR: Upload failed: %v", err)
+        return h.failed(path, err, h.moveToFailed(path, err, h.attempts(path)+1))
+    }
+
+    if h.dryRun {
+        dest := h.successDest(path)
+        h.logDryRun(uploadPath, attrs, dest)
+        return &Result{File: path, Status: StatusDryRun, Attributes: attrs, Dest: dest}, nil
     }
 
+    h.notify(notify.Event{
+        Type:      notify.EventUploadStarted,
+        File:      path,
+        Name:      attrs.Name,
+        ChannelID: attrs.ChannelID,
+    })
+
     // Attempt upload
-    result, err := h.client.Upload(path, attrs)
+    result, err := client.Upload(ctx, uploadPath, attrs)
+    if ctx.Err() != nil {
+        return nil, h.interrupted(ctx, path)
+    }
     if err != nil {
-        return h.handleFailure(path, err)
+        return h.failed(path, err, h.handleUploadError(path, err))
     }
 
     h.logger.Printf("Upload successful: %s (UUID: %s)", result.Video.Name, result.Video.UUID)
 
+    videoURL := client.VideoURL(result.Video.UUID)
+    h.notify(notify.Event{
+        Type:      notify.EventUploadSucceeded,
+        File:      path,
+        Name:      result.Video.Name,
+        ChannelID: attrs.ChannelID,
+        UUID:      result.Video.UUID,
+        URL:       videoURL,
+    })
+
     // Move to done folder or delete
-    return h.handleSuccess(path)
-}
+    destPath, err := h.handleSuccess(path)
+    if err != nil {
+        return nil, err
+    }
 
-func (h *UploadHandler) handleSuccess(path string) error {
-    if h.config.Watcher.DonePath != "" {
-        // Move to done folder
-        destPath := filepath.Join(h.config.Watcher.DonePath, filepath.Base(path))
+    if hook := cfg.Watcher.Hooks.PostSuccess; hookEnabled(hook) {
+        // Post hooks run to completion (or their timeout) even during shutdown
+        _, err := h.runHook(context.Background(), hook, hookInput{
+            Event:      hookPostSuccess,
+            File:       path,
+            UploadFile: uploadPath,
+            Dest:       destPath,
+            Name:       result.Video.Name,
+            ChannelID:  attrs.ChannelID,
+            UUID:       result.Video.UUID,
+            URL:        videoURL,
+        })
+        if err != nil {
+            h.logger.Printf("WARNING: %v", err)
+        }
+    }
 
-        // Handle filename collision
-        destPath = h.ensureUniqueFilename(destPath)
+    return &Result{
+        File:       path,
+        Status:     StatusUploaded,
+        Attributes: attrs,
+        UUID:       result.Video.UUID,
+        URL:        videoURL,
+        Dest:       destPath,
+    }, nil
+}
 
+// handleSuccess moves the file to the done folder, or deletes it, and
+// returns where it went ("" when deleted)
+func (h *UploadHandler) handleSuccess(path string) (string, error) {
+    destPath := h.successDest(path)
+    if h.keepFiles {
+        h.logger.Printf("Leaving file in place: %s", path)
+    } else if destPath != "" {
+        // Move to done folder
         if err := os.Rename(path, destPath); err != nil {
-            h.logger.Printf("Error moving file to done folder: %v", err)
+            h.logger.Printf("ERROR: Could not move file to done folder: %v", err)
             // Try copying instead
             if err := h.copyFile(path, destPath); err != nil {
-                return fmt.Errorf("copying to done folder: %w", err)
+                return "", fmt.Errorf("copying to done folder: %w", err)
             }
             if err := os.Remove(path); err != nil {
-                h.logger.Printf("Warning: could not remove original file: %v", err)
+                h.logger.Printf("WARNING: could not remove original file: %v", err)
             }
         }
         h.logger.Printf("Moved to done: %s", destPath)
     } else {
         // Delete file
         if err := os.Remove(path); err != nil {
-            return fmt.Errorf("deleting file: %w", err)
+            return "", fmt.Errorf("deleting file: %w", err)
         }
         h.logger.Printf("Deleted: %s", path)
     }
 
     // Clear retry count
+    h.mu.Lock()
     delete(h.retryCount, path)
-    return nil
+    h.mu.Unlock()
+    return destPath, nil
+}
+
+// successDest returns where an uploaded file goes: a free name in the done
+// folder, "" if it is deleted, or the file itself if it is kept
+func (h *UploadHandler) successDest(path string) string {
+    if h.keepFiles {
+        return path
+    }
+    if donePath := h.config.Load().Watcher.DonePath; donePath != "" {
+        return h.ensureUniqueFilename(filepath.Join(donePath, filepath.Base(path)))
+    }
+    return ""
+}
+
+// failedDest returns where a failed file goes
+func (h *UploadHandler) failedDest(path string) string {
+    if h.keepFiles {
+        return path
+    }
+    if failedPath := h.config.Load().Watcher.FailedPath; failedPath != "" {
+        return h.ensureUniqueFilename(filepath.Join(failedPath, filepath.Base(path)))
+    }
+    return path + ".failed"
+}
+
+// logDryRun logs the attributes a file would be uploaded with
+func (h *UploadHandler) logDryRun(path string, attrs peertube.VideoAttributes, dest string) {
+    h.logger.Printf("Dry run: would upload %s", path)
+    h.logger.Printf("  name=%q channel=%d category=%d licence=%d privacy=%d language=%q",
+        attrs.Name, attrs.ChannelID, attrs.Category, attrs.Licence, attrs.Privacy, attrs.Language)
+    h.logger.Printf("  tags=%q nsfw=%t downloadEnabled=%t commentsEnabled=%t waitTranscoding=%t",
+        attrs.Tags, attrs.NSFW, attrs.DownloadEnabled, attrs.CommentsEnabled, attrs.WaitTranscoding)
+    if attrs.Description != "" {
+        h.logger.Printf("  description=%q", attrs.Description)
+    }
+
+    switch {
+    case h.keepFiles:
+        h.logger.Printf("  then leave the file in place")
+    case dest == "":
+        h.logger.Printf("  then delete the file")
+    default:
+        h.logger.Printf("  then move it to %s", dest)
+    }
+}
+
+// handleUploadError decides what a failed request means for the file,
+// based on the kind of error the server returned
+func (h *UploadHandler) handleUploadError(path string, err error) error {
+    switch {
+    case peertube.IsQuotaExceeded(err):
+        h.logger.Printf("WARNING: Upload refused: %v", err)
+        return &DeferError{Reason: "server reports quota reached", RetryAfter: quotaRetryInterval}
+
+    case peertube.IsAuthError(err), peertube.IsNetworkError(err):
+        // Retrying won't help until the server is back or the credentials
+        // are fixed, so the file is held without counting an attempt
+        reason := "PeerTube server not reachable"
+        if peertube.IsAuthError(err) {
+            // Authenticate again on the next attempt
+            h.client.Load().InvalidateToken()
+            reason = "PeerTube authentication failed"
+        }
+        h.logger.Printf("WARNING: Upload failed: %v", err)
+        if h.onServerDown != nil {
+            h.onServerDown(reason)
+        }
+        return h.holdPaused(reason)
+
+    case !peertube.IsRetryable(err):
+        h.logger.Printf("ERROR: Upload failed: %v", err)
+        h.logger.Printf("Error is not retryable, moving to failed folder")
+        return h.moveToFailed(path, err, h.attempts(path)+1)
+
+    default:
+        return h.handleFailure(path, err)
+    }
 }
 
 func (h *UploadHandler) handleFailure(path string, uploadErr error) error {
-    h.logger.Printf("Upload failed: %v", uploadErr)
+    h.logger.Printf("WARNING: Upload failed: %v", uploadErr)
 
     // Increment retry count
+    h.mu.Lock()
     h.retryCount[path]++
     retries := h.retryCount[path]
+    h.mu.Unlock()
 
-    if retries < h.config.Watcher.MaxRetries {
-        h.logger.Printf("Will retry (%d/%d)", retries, h.config.Watcher.MaxRetries)
-        return uploadErr
+    if retries < h.config.Load().Watcher.MaxRetries {
+        delay := retryDelay(retries)
+        h.logger.Printf("Will retry (%d/%d) in %s", retries, h.config.Load().Watcher.MaxRetries, delay)
+        return &DeferError{Reason: "retrying failed upload", RetryAfter: delay, Attempt: retries}
     }
 
     // Max retries reached, move to failed folder
-    h.logger.Printf("Max retries reached, moving to failed folder")
+    h.logger.Printf("ERROR: Max retries reached, moving to failed folder")
+    return h.moveToFailed(path, uploadErr, retries)
+}
 
-    if h.config.Watcher.FailedPath != "" {
-        destPath := filepath.Join(h.config.Watcher.FailedPath, filepath.Base(path))
-        destPath = h.ensureUniqueFilename(destPath)
+// moveToFailed gives up on a file: it notifies, moves the file to the failed
+// folder (or renames it with .failed) and runs the post-failure hook
+func (h *UploadHandler) moveToFailed(path string, cause error, attempts int) error {
+    destPath := h.failedDest(path)
+    if h.dryRun {
+        h.logger.Printf("Dry run: would give up on %s (%v) and move it to %s", path, cause, destPath)
+        h.mu.Lock()
+        delete(h.retryCount, path)
+        h.mu.Unlock()
+        return nil
+    }
 
+    h.notify(notify.Event{
+        Type:     notify.EventUploadFailed,
+        File:     path,
+        Error:    cause.Error(),
+        Attempts: attempts,
+    })
+
+    if h.keepFiles {
+        h.logger.Printf("Leaving failed file in place: %s", path)
+    } else if h.config.Load().Watcher.FailedPath != "" {
         if err := os.Rename(path, destPath); err != nil {
-            h.logger.Printf("Error moving file to failed folder: %v", err)
+            h.logger.Printf("ERROR: Could not move file to failed folder: %v", err)
             // Try copying instead
             if err := h.copyFile(path, destPath); err != nil {
                 return fmt.Errorf("copying to failed folder: %w", err)
             }
             if err := os.Remove(path); err != nil {
-                h.logger.Printf("Warning: could not remove original file: %v", err)
+                h.logger.Printf("WARNING: could not remove original file: %v", err)
             }
         }
         h.logger.Printf("Moved to failed: %s", destPath)
     } else {
         // Rename with .failed extension
-        failedPath := path + ".failed"
-        if err := os.Rename(path, failedPath); err != nil {
+        if err := os.Rename(path, destPath); err != nil {
             return fmt.Errorf("renaming to .failed: %w", err)
         }
-        h.logger.Printf("Renamed to: %s", failedPath)
+        h.logger.Printf("Renamed to: %s", destPath)
+    }
+
+    // Leave a note explaining why the file was rejected
+    var rejectErr *RejectError
+    if errors.As(cause, &rejectErr) && !h.keepFiles {
+        reasonPath := destPath + ".reason.txt"
+        if err := os.WriteFile(reasonPath, []byte(rejectErr.Reason+"\n"), 0644); err != nil {
+            h.logger.Printf("WARNING: could not write reason file: %v", err)
+        }
     }
 
     // Clear retry count
+    h.mu.Lock()
     delete(h.retryCount, path)
+    h.mu.Unlock()
+
+    if hook := h.config.Load().Watcher.Hooks.PostFailure; hookEnabled(hook) {
+        _, err := h.runHook(context.Background(), hook, hookInput{
+            Event:    hookPostFailure,
+            File:     path,
+            Dest:     destPath,
+            Error:    cause.Error(),
+            Attempts: attempts,
+        })
+        if err != nil {
+            h.logger.Printf("WARNING: %v", err)
+        }
+    }
+
+    return nil
+}
+
+// failed reports a file that was given up on, unless err shows it was
+// deferred or could not be moved
+func (h *UploadHandler) failed(path string, cause, err error) (*Result, error) {
+    if err != nil {
+        return nil, err
+    }
+    return &Result{File: path, Status: StatusFailed, Error: cause.Error()}, nil
+}
+
+// channelID returns the configured channel, checking it against the user's
+// channels once per configuration. A channel that isn't one of the user's
+// holds the file with a *DeferError until the setting is fixed, rather than
+// failing every upload attempt.
+func (h *UploadHandler) channelID(ctx context.Context, cfg *config.Config, client *peertube.Client) (int, error) {
+    h.mu.Lock()
+    if h.channelConfig == cfg {
+        defer h.mu.Unlock()
+        return h.channel, nil
+    }
+    h.mu.Unlock()
+
+    defaults := cfg.PeerTube.Defaults
+    channels, err := client.ListChannels(ctx)
+    if err != nil {
+        // The channel resolved at startup is still good enough
+        if defaults.ChannelID != 0 {
+            return defaults.ChannelID, nil
+        }
+        return 0, err
+    }
+    channel, err := config.FindChannel(channels, defaults.Channel, defaults.ChannelID)
+    if err != nil {
+        return 0, &DeferError{Reason: err.Error(), RetryAfter: channelRetryInterval}
+    }
+    if channel.ID != defaults.ChannelID {
+        h.logger.Printf("Using channel %d (%s)", channel.ID, channel.DisplayName)
+    }
+
+    h.mu.Lock()
+    h.channel = channel.ID
+    h.channelConfig = cfg
+    h.mu.Unlock()
+    return channel.ID, nil
+}
+
+// checkQuota returns a *DeferError when uploading path would exceed the
+// user's quota, or a *RejectError when the file is larger than the quota
+// itself and would never fit. Failing to fetch the quota does not block the
+// upload.
+func (h *UploadHandler) checkQuota(ctx context.Context, path string) error {
+    info, err := os.Stat(path)
+    if err != nil {
+        return nil
+    }
+
+    quota, err := h.client.Load().GetQuota(ctx)
+    if err != nil {
+        h.logger.Printf("WARNING: could not check quota: %v", err)
+        return nil
+    }
+
+    h.logger.Printf("Quota: %s", quota)
+    if reason := quota.TooLarge(info.Size()); reason != "" {
+        return &RejectError{Reason: reason}
+    }
+    if reason := quota.Check(info.Size()); reason != "" {
+        if h.dryRun {
+            h.logger.Printf("Dry run: would hold file: %s", reason)
+            return nil
+        }
+        return &DeferError{Reason: reason, RetryAfter: quotaRetryInterval}
+    }
     return nil
 }
 
+// interrupted leaves a file whose processing was cancelled where it is, so
+// it is picked up again on the next start. It does not count as an attempt.
+func (h *UploadHandler) interrupted(ctx context.Context, path string) error {
+    h.logger.Printf("WARNING: Upload interrupted, leaving file for next run: %s", path)
+    h.notify(notify.Event{
+        Type:     notify.EventUploadInterrupted,
+        File:     path,
+        Error:    ctx.Err().Error(),
+        Attempts: h.attempts(path),
+    })
+    return ctx.Err()
+}
+
+func (h *UploadHandler) attempts(path string) int {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    return h.retryCount[path]
+}
+
+func retryDelay(attempt int) time.Duration {
+    delay := retryBaseDelay << uint(attempt-1)
+    if delay > retryMaxDelay || delay <= 0 {
+        delay = retryMaxDelay
+    }
+    return delay
+}
+
+func (h *UploadHandler) notify(event notify.Event) {
+    if h.notifier != nil {
+        h.notifier.Notify(event)
+    }
+}
+
 func (h *UploadHandler) ensureUniqueFilename(path string) string {
     if _, err := os.Stat(path); os.IsNotExist(err) {
         return path
@@ -0,0 +1,108 @@
+package watcher
+
+import (
+    "context"
+    "errors"
+    "io"
+    "log"
+    "net"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+    "github.com/dsu-teknik/peertube-monitor/pkg/notify"
+    "github.com/dsu-teknik/peertube-monitor/pkg/peertube"
+)
+
+// eventRecorder keeps the notifications it is sent
+type eventRecorder struct {
+    events []notify.Event
+}
+
+func (r *eventRecorder) Notify(event notify.Event) {
+    r.events = append(r.events, event)
+}
+
+func TestUnreachableServerPausesWithoutCountingAttempts(t *testing.T) {
+    // A port nothing listens on
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    url := "http://" + ln.Addr().String()
+    ln.Close()
+
+    dir := t.TempDir()
+    cfg := &config.Config{}
+    cfg.Watcher.WatchPath = dir
+    cfg.Watcher.MaxRetries = 1
+    h := NewUploadHandler(peertube.NewClient(url, "user", "secret"), cfg, nil, log.New(io.Discard, "", 0))
+
+    var reasons []string
+    h.OnServerDown(func(reason string) {
+        reasons = append(reasons, reason)
+    })
+
+    path := filepath.Join(dir, "video.mp4")
+    writeFile(t, path)
+
+    // More attempts than maxRetries allows still leave the file queued
+    for i := 0; i < 3; i++ {
+        _, err := h.HandleFile(context.Background(), path)
+        var deferErr *DeferError
+        if !errors.As(err, &deferErr) {
+            t.Fatalf("attempt %d: err = %v, want a *DeferError", i+1, err)
+        }
+        if deferErr.Attempt != 0 {
+            t.Errorf("attempt %d counted as retry %d", i+1, deferErr.Attempt)
+        }
+    }
+    if h.attempts(path) != 0 {
+        t.Errorf("attempts = %d, want 0", h.attempts(path))
+    }
+    if len(reasons) != 3 || reasons[0] != "PeerTube server not reachable" {
+        t.Errorf("server down reasons = %q", reasons)
+    }
+}
+
+func TestRejectionCountsAsAnAttempt(t *testing.T) {
+    validation, dir := probeSetup(t)
+    watch := filepath.Join(dir, "watch")
+    if err := os.Mkdir(watch, 0755); err != nil {
+        t.Fatal(err)
+    }
+
+    // The post-failure hook notes the attempt count it is given
+    seen := filepath.Join(dir, "attempts")
+    hook := filepath.Join(dir, "failed.sh")
+    script := "#!/bin/sh\necho \"$PEERTUBE_MONITOR_ATTEMPTS\" > " + seen + "\n"
+    if err := os.WriteFile(hook, []byte(script), 0755); err != nil {
+        t.Fatal(err)
+    }
+
+    cfg := &config.Config{}
+    cfg.Watcher.WatchPath = watch
+    cfg.Watcher.MaxRetries = 3
+    cfg.Watcher.Validation = validation
+    cfg.Watcher.Hooks.PostFailure = config.HookConfig{Command: []string{hook}, Timeout: 10}
+    events := &eventRecorder{}
+    h := NewUploadHandler(nil, cfg, events, log.New(io.Discard, "", 0))
+
+    path := videoFile(t, watch, "audio.mp4", probeAudioOnly)
+    if _, err := h.HandleFile(context.Background(), path); err != nil {
+        t.Fatal(err)
+    }
+
+    if len(events.events) != 1 || events.events[0].Type != notify.EventUploadFailed || events.events[0].Attempts != 1 {
+        t.Errorf("events = %+v, want one failure after 1 attempt", events.events)
+    }
+    out, err := os.ReadFile(seen)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := strings.TrimSpace(string(out)); got != "1" {
+        t.Errorf("post-failure hook got attempts %q, want 1", got)
+    }
+}
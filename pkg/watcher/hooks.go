@@ -0,0 +1,221 @@
+package watcher
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+    "github.com/dsu-teknik/peertube-monitor/pkg/peertube"
+)
+
+const (
+    hookPreUpload   = "pre-upload"
+    hookPostSuccess = "post-success"
+    hookPostFailure = "post-failure"
+)
+
+// hookInput is passed to hook commands as JSON on stdin and as
+// PEERTUBE_MONITOR_* environment variables
+type hookInput struct {
+    Event      string `json:"event"`
+    File       string `json:"file"`
+    UploadFile string `json:"uploadFile,omitempty"`
+    Dest       string `json:"dest,omitempty"`
+    Name       string `json:"name,omitempty"`
+    ChannelID  int    `json:"channelId,omitempty"`
+    UUID       string `json:"uuid,omitempty"`
+    URL        string `json:"url,omitempty"`
+    Error      string `json:"error,omitempty"`
+    Attempts   int    `json:"attempts,omitempty"`
+    TmpDir     string `json:"tmpDir"` // working folder of the hook, removed afterwards
+}
+
+// hookMetadata is what a pre-upload hook may print as JSON on stdout to
+// replace the uploaded file or override video attributes
+type hookMetadata struct {
+    Path        string   `json:"path"`
+    Name        *string  `json:"name"`
+    Description *string  `json:"description"`
+    Language    *string  `json:"language"`
+    Tags        []string `json:"tags"`
+    NSFW        *bool    `json:"nsfw"`
+}
+
+func (in hookInput) env() []string {
+    vars := map[string]string{
+        "EVENT":       in.Event,
+        "FILE":        in.File,
+        "UPLOAD_FILE": in.UploadFile,
+        "DEST":        in.Dest,
+        "NAME":        in.Name,
+        "UUID":        in.UUID,
+        "URL":         in.URL,
+        "ERROR":       in.Error,
+        "TMPDIR":      in.TmpDir,
+    }
+    if in.ChannelID != 0 {
+        vars["CHANNEL_ID"] = strconv.Itoa(in.ChannelID)
+    }
+    if in.Attempts != 0 {
+        vars["ATTEMPTS"] = strconv.Itoa(in.Attempts)
+    }
+
+    env := os.Environ()
+    for key, val := range vars {
+        if val != "" {
+            env = append(env, "PEERTUBE_MONITOR_"+key+"="+val)
+        }
+    }
+    return env
+}
+
+// runHook executes a hook command and returns its stdout. Anything the
+// command writes to stderr is logged. The command runs in in.TmpDir, or in
+// a temporary folder that is removed afterwards if that is empty, so files
+// it writes never land in the watch folder.
+func (h *UploadHandler) runHook(ctx context.Context, hook config.HookConfig, in hookInput) ([]byte, error) {
+    if in.TmpDir == "" {
+        dir, err := newHookDir()
+        if err != nil {
+            return nil, err
+        }
+        defer h.removeHookDir(dir)
+        in.TmpDir = dir
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, time.Duration(hook.Timeout)*time.Second)
+    defer cancel()
+
+    stdin, err := json.Marshal(in)
+    if err != nil {
+        return nil, fmt.Errorf("encoding hook input: %w", err)
+    }
+
+    var stdout, stderr bytes.Buffer
+    cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+    cmd.Env = in.env()
+    cmd.Dir = in.TmpDir
+    cmd.Stdin = bytes.NewReader(stdin)
+    cmd.Stdout = &stdout
+    cmd.Stderr = &stderr
+
+    h.logger.Printf("Running %s hook: %s", in.Event, strings.Join(hook.Command, " "))
+    err = cmd.Run()
+
+    scanner := bufio.NewScanner(&stderr)
+    for scanner.Scan() {
+        h.logger.Printf("  [%s hook] %s", in.Event, scanner.Text())
+    }
+
+    if ctx.Err() == context.DeadlineExceeded {
+        return nil, fmt.Errorf("%s hook timed out after %ds", in.Event, hook.Timeout)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("%s hook: %w", in.Event, err)
+    }
+
+    return stdout.Bytes(), nil
+}
+
+// parsePreHookOutput interprets pre-upload hook output: either a JSON object
+// (hookMetadata) or a line naming a replacement file. Relative paths
+// are resolved against dir, the hook's working folder. A replacement must
+// not be inside the watch folder, where it would be queued as a new video.
+func parsePreHookOutput(path, dir, watchPath string, out []byte) (*hookMetadata, error) {
+    text := strings.TrimSpace(string(out))
+    if text == "" {
+        return nil, nil
+    }
+
+    // Hooks may print progress before the result, so fall back to the last line
+    meta := &hookMetadata{}
+    if err := json.Unmarshal([]byte(text), meta); err != nil {
+        lines := strings.Split(text, "\n")
+        last := strings.TrimSpace(lines[len(lines)-1])
+        if strings.HasPrefix(last, "{") {
+            meta = &hookMetadata{}
+            if err := json.Unmarshal([]byte(last), meta); err != nil {
+                return nil, fmt.Errorf("parsing pre-upload hook output: %w", err)
+            }
+        } else {
+            meta = &hookMetadata{Path: last}
+        }
+    }
+
+    if meta.Path != "" {
+        if !filepath.IsAbs(meta.Path) {
+            meta.Path = filepath.Join(dir, meta.Path)
+        }
+        meta.Path = filepath.Clean(meta.Path)
+        if meta.Path != path && config.IsWithin(meta.Path, watchPath) {
+            return nil, fmt.Errorf("pre-upload hook replacement file %s is inside the watch folder; write it to PEERTUBE_MONITOR_TMPDIR instead", meta.Path)
+        }
+        if _, err := os.Stat(meta.Path); err != nil {
+            return nil, fmt.Errorf("pre-upload hook replacement file: %w", err)
+        }
+    }
+
+    return meta, nil
+}
+
+// apply overrides video attributes with values printed by the hook. The
+// language may be a code or a name; it is resolved against languages when
+// the server's languages are known.
+func (m *hookMetadata) apply(attrs *peertube.VideoAttributes, languages map[string]string) error {
+    if m == nil {
+        return nil
+    }
+    if m.Name != nil {
+        attrs.Name = *m.Name
+    }
+    if m.Description != nil {
+        attrs.Description = *m.Description
+    }
+    if m.Language != nil {
+        attrs.Language = *m.Language
+        if languages != nil {
+            code, err := config.ResolveLanguage(*m.Language, languages)
+            if err != nil {
+                return fmt.Errorf("pre-upload hook %w", err)
+            }
+            attrs.Language = code
+        }
+    }
+    if m.Tags != nil {
+        attrs.Tags = m.Tags
+    }
+    if m.NSFW != nil {
+        attrs.NSFW = *m.NSFW
+    }
+    return nil
+}
+
+// newHookDir creates a working folder for a hook
+func newHookDir() (string, error) {
+    dir, err := os.MkdirTemp("", "peertube-monitor-hook-")
+    if err != nil {
+        return "", fmt.Errorf("creating hook folder: %w", err)
+    }
+    return dir, nil
+}
+
+// removeHookDir deletes a hook's working folder with everything the hook
+// left in it, such as a replacement file
+func (h *UploadHandler) removeHookDir(dir string) {
+    if err := os.RemoveAll(dir); err != nil {
+        h.logger.Printf("WARNING: could not remove hook folder: %v", err)
+    }
+}
+
+func hookEnabled(hook config.HookConfig) bool {
+    return len(hook.Command) > 0
+}
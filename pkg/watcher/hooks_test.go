@@ -0,0 +1,103 @@
+package watcher
+
+import (
+    "context"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+)
+
+func TestParsePreHookOutput(t *testing.T) {
+    watch := t.TempDir()
+    dir := t.TempDir()
+    original := filepath.Join(watch, "clip.mkv")
+    writeFile(t, original)
+    writeFile(t, filepath.Join(dir, "clip.mp4"))
+    writeFile(t, filepath.Join(watch, "other.mp4"))
+
+    tests := []struct {
+        name string
+        out  string
+        path string // expected replacement ("" = none)
+        err  string
+    }{
+        {"empty", "", "", ""},
+        {"relative to the hook folder", "clip.mp4\n", filepath.Join(dir, "clip.mp4"), ""},
+        {"progress before the result", "remuxing...\ndone\n" + filepath.Join(dir, "clip.mp4"), filepath.Join(dir, "clip.mp4"), ""},
+        {"json", `{"path": "clip.mp4", "name": "Final"}`, filepath.Join(dir, "clip.mp4"), ""},
+        {"original file", original, original, ""},
+        {"inside the watch folder", filepath.Join(watch, "other.mp4"), "", "inside the watch folder"},
+        {"missing", "gone.mp4", "", "replacement file"},
+        {"broken json", "progress\n{\"path\": ", "", "parsing pre-upload hook output"},
+    }
+    for _, tt := range tests {
+        meta, err := parsePreHookOutput(original, dir, watch, []byte(tt.out))
+        if tt.err != "" {
+            if err == nil || !strings.Contains(err.Error(), tt.err) {
+                t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+            continue
+        }
+        var path string
+        if meta != nil {
+            path = meta.Path
+        }
+        if path != tt.path {
+            t.Errorf("%s: path = %q, want %q", tt.name, path, tt.path)
+        }
+    }
+}
+
+func TestPreUploadHookRunsOutsideWatchFolder(t *testing.T) {
+    validation, dir := probeSetup(t)
+    watch := filepath.Join(dir, "watch")
+    if err := os.Mkdir(watch, 0755); err != nil {
+        t.Fatal(err)
+    }
+
+    // The hook remuxes next to itself and notes where it ran
+    seen := filepath.Join(dir, "hook-dir")
+    hook := filepath.Join(dir, "remux.sh")
+    script := "#!/bin/sh\npwd > " + seen + "\ncp \"$PEERTUBE_MONITOR_FILE\" clip.mp4\necho clip.mp4\n"
+    if err := os.WriteFile(hook, []byte(script), 0755); err != nil {
+        t.Fatal(err)
+    }
+
+    cfg := &config.Config{}
+    cfg.Watcher.WatchPath = watch
+    cfg.Watcher.MaxRetries = 3
+    cfg.Watcher.Validation = validation
+    cfg.Watcher.Hooks.PreUpload = config.HookConfig{Command: []string{hook}, Timeout: 10, OnError: "fail"}
+    h := NewUploadHandler(nil, cfg, nil, log.New(io.Discard, "", 0))
+
+    // The replacement is not a video to the fake ffprobe, which ends the
+    // attempt before uploading
+    path := videoFile(t, watch, "clip.mkv", "")
+    if _, err := h.HandleFile(context.Background(), path); err != nil {
+        t.Fatal(err)
+    }
+
+    out, err := os.ReadFile(seen)
+    if err != nil {
+        t.Fatal(err)
+    }
+    hookDir := strings.TrimSpace(string(out))
+    if config.IsWithin(hookDir, watch) {
+        t.Errorf("hook ran in %s, inside the watch folder", hookDir)
+    }
+    if _, err := os.Stat(hookDir); !os.IsNotExist(err) {
+        t.Errorf("hook folder %s not removed", hookDir)
+    }
+    if _, err := os.Stat(filepath.Join(watch, "clip.mp4")); !os.IsNotExist(err) {
+        t.Error("replacement written to the watch folder")
+    }
+}
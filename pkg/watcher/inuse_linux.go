@@ -0,0 +1,39 @@
+//go:build linux
+
+package watcher
+
+import (
+    "os"
+    "path/filepath"
+    "strconv"
+)
+
+// inUse reports whether another process has path open, by looking through
+// the open files of each process in /proc. Processes of other users are
+// only visible when running as root.
+func inUse(path string) bool {
+    procs, err := os.ReadDir("/proc")
+    if err != nil {
+        return false
+    }
+
+    self := os.Getpid()
+    for _, proc := range procs {
+        pid, err := strconv.Atoi(proc.Name())
+        if err != nil || pid == self {
+            continue
+        }
+
+        fdDir := filepath.Join("/proc", proc.Name(), "fd")
+        fds, err := os.ReadDir(fdDir)
+        if err != nil {
+            continue
+        }
+        for _, fd := range fds {
+            if target, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err == nil && target == path {
+                return true
+            }
+        }
+    }
+    return false
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !windows
+
+package watcher
+
+// inUse returns false; open files can't be detected on this platform.
+func inUse(path string) bool {
+    return false
+}
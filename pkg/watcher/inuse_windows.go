@@ -0,0 +1,25 @@
+//go:build windows
+
+package watcher
+
+import (
+    "errors"
+
+    "golang.org/x/sys/windows"
+)
+
+// inUse reports whether another process has path open, by trying to open
+// it without sharing it
+func inUse(path string) bool {
+    name, err := windows.UTF16PtrFromString(path)
+    if err != nil {
+        return false
+    }
+
+    h, err := windows.CreateFile(name, windows.GENERIC_READ, 0, nil, windows.OPEN_EXISTING, windows.FILE_ATTRIBUTE_NORMAL, 0)
+    if err != nil {
+        return errors.Is(err, windows.ERROR_SHARING_VIOLATION)
+    }
+    windows.CloseHandle(h)
+    return false
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package watcher
+
+import "golang.org/x/sys/unix"
+
+// Filesystem magic numbers from statfs(2) for network filesystems
+var networkFSTypes = map[uint32]string{
+    0x6969:     "nfs",
+    0x517b:     "smb",
+    0xff534d42: "cifs",
+    0xfe534d42: "smb2",
+    0x01021997: "9p",
+    0x00c36400: "ceph",
+    0x5346414f: "afs",
+    0x73757245: "coda",
+    0x564c:     "ncp",
+}
+
+// isNetworkFS reports whether path is on a network filesystem
+func isNetworkFS(path string) (bool, error) {
+    var st unix.Statfs_t
+    if err := unix.Statfs(path, &st); err != nil {
+        return false, err
+    }
+    _, remote := networkFSTypes[uint32(st.Type)]
+    return remote, nil
+}
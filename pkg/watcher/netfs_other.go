@@ -0,0 +1,9 @@
+//go:build !linux && !windows
+
+package watcher
+
+// isNetworkFS returns false; network filesystems are not detected on this
+// platform, so "auto" uses fsnotify.
+func isNetworkFS(path string) (bool, error) {
+    return false, nil
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package watcher
+
+import (
+    "path/filepath"
+
+    "golang.org/x/sys/windows"
+)
+
+// isNetworkFS reports whether path is on a network share, either a UNC
+// path or a mapped drive
+func isNetworkFS(path string) (bool, error) {
+    path, err := filepath.Abs(path)
+    if err != nil {
+        return false, err
+    }
+
+    root := filepath.VolumeName(path) + `\`
+    name, err := windows.UTF16PtrFromString(root)
+    if err != nil {
+        return false, err
+    }
+    return windows.GetDriveType(name) == windows.DRIVE_REMOTE, nil
+}
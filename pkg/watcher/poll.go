@@ -0,0 +1,179 @@
+package watcher
+
+import (
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "github.com/fsnotify/fsnotify"
+)
+
+// pollBackend scans the watched folders every interval and reports files
+// that appeared, changed size or modification time, or disappeared. It
+// works on any filesystem, at the cost of noticing changes later.
+type pollBackend struct {
+    interval time.Duration
+    events   chan fsnotify.Event
+    errors   chan error
+    done     chan struct{}
+
+    mu    sync.Mutex
+    dirs  map[string]map[string]polledFile
+    close sync.Once
+}
+
+type polledFile struct {
+    size    int64
+    modTime time.Time
+}
+
+func newPollBackend(interval time.Duration) *pollBackend {
+    b := &pollBackend{
+        interval: interval,
+        events:   make(chan fsnotify.Event),
+        errors:   make(chan error),
+        done:     make(chan struct{}),
+        dirs:     make(map[string]map[string]polledFile),
+    }
+    go b.run()
+    return b
+}
+
+// Add starts polling dir. Files already there are not reported.
+func (b *pollBackend) Add(dir string) error {
+    files, err := scanDir(dir)
+    if err != nil {
+        return err
+    }
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.dirs[filepath.Clean(dir)] = files
+    return nil
+}
+
+func (b *pollBackend) Remove(dir string) error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    delete(b.dirs, filepath.Clean(dir))
+    return nil
+}
+
+func (b *pollBackend) Events() <-chan fsnotify.Event { return b.events }
+func (b *pollBackend) Errors() <-chan error          { return b.errors }
+
+func (b *pollBackend) Close() error {
+    b.close.Do(func() { close(b.done) })
+    return nil
+}
+
+func (b *pollBackend) run() {
+    defer close(b.events)
+    defer close(b.errors)
+
+    ticker := time.NewTicker(b.interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-b.done:
+            return
+        case <-ticker.C:
+        }
+
+        b.mu.Lock()
+        var dirs []string
+        for dir := range b.dirs {
+            dirs = append(dirs, dir)
+        }
+        b.mu.Unlock()
+
+        for _, dir := range dirs {
+            if !b.poll(dir) {
+                return
+            }
+        }
+    }
+}
+
+// poll compares dir with the previous scan and sends the differences. It
+// returns false once the backend is closed.
+func (b *pollBackend) poll(dir string) bool {
+    files, err := scanDir(dir)
+    if err != nil {
+        // Keep the previous state so a share that is briefly unreachable
+        // does not look like every file was removed
+        return b.send(nil, err)
+    }
+
+    b.mu.Lock()
+    old, ok := b.dirs[dir]
+    if ok {
+        b.dirs[dir] = files
+    }
+    b.mu.Unlock()
+    if !ok {
+        return true // removed meanwhile
+    }
+
+    for name, file := range files {
+        prev, seen := old[name]
+        switch {
+        case !seen:
+            if !b.send(&fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Create}, nil) {
+                return false
+            }
+        case prev.size != file.size || !prev.modTime.Equal(file.modTime):
+            if !b.send(&fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Write}, nil) {
+                return false
+            }
+        }
+    }
+    for name := range old {
+        if _, exists := files[name]; !exists {
+            if !b.send(&fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Remove}, nil) {
+                return false
+            }
+        }
+    }
+    return true
+}
+
+func (b *pollBackend) send(event *fsnotify.Event, err error) bool {
+    if event != nil {
+        select {
+        case b.events <- *event:
+            return true
+        case <-b.done:
+            return false
+        }
+    }
+    select {
+    case b.errors <- err:
+        return true
+    case <-b.done:
+        return false
+    }
+}
+
+// scanDir returns the size and modification time of the files in dir
+func scanDir(dir string) (map[string]polledFile, error) {
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return nil, err
+    }
+
+    files := make(map[string]polledFile, len(entries))
+    for _, entry := range entries {
+        if entry.IsDir() {
+            continue
+        }
+        info, err := entry.Info()
+        if err != nil {
+            continue // removed since the listing
+        }
+        files[entry.Name()] = polledFile{size: info.Size(), modTime: info.ModTime()}
+    }
+    return files, nil
+}
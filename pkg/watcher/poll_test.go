@@ -0,0 +1,157 @@
+package watcher
+
+import (
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/fsnotify/fsnotify"
+)
+
+// newPolledDir returns a poll backend without its ticker, polled by hand,
+// watching a new folder that already holds old.mp4
+func newPolledDir(t *testing.T) (*pollBackend, string) {
+    dir := t.TempDir()
+    writeFile(t, filepath.Join(dir, "old.mp4"))
+    if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+        t.Fatal(err)
+    }
+
+    b := &pollBackend{
+        events: make(chan fsnotify.Event, 10),
+        errors: make(chan error, 10),
+        done:   make(chan struct{}),
+        dirs:   make(map[string]map[string]polledFile),
+    }
+    if err := b.Add(dir); err != nil {
+        t.Fatal(err)
+    }
+    return b, dir
+}
+
+// polled runs one scan of dir and returns the events as "op name"
+func polled(t *testing.T, b *pollBackend, dir string) []string {
+    t.Helper()
+    if !b.poll(dir) {
+        t.Fatal("poll stopped")
+    }
+
+    var got []string
+    for {
+        select {
+        case event := <-b.events:
+            got = append(got, event.Op.String()+" "+filepath.Base(event.Name))
+        case err := <-b.errors:
+            got = append(got, "error "+err.Error())
+        default:
+            sort.Strings(got)
+            return got
+        }
+    }
+}
+
+func TestPollReportsChanges(t *testing.T) {
+    b, dir := newPolledDir(t)
+    old := filepath.Join(dir, "old.mp4")
+
+    // Files present when polling starts are not reported
+    if got := polled(t, b, dir); len(got) != 0 {
+        t.Errorf("first scan: %q", got)
+    }
+
+    writeFile(t, filepath.Join(dir, "new.mp4"))
+    if got := polled(t, b, dir); len(got) != 1 || got[0] != "CREATE new.mp4" {
+        t.Errorf("created: %q", got)
+    }
+
+    // A change of size or of modification time alone is a write
+    if err := os.WriteFile(old, []byte("more video data"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    later := time.Now().Add(time.Hour)
+    if err := os.Chtimes(filepath.Join(dir, "new.mp4"), later, later); err != nil {
+        t.Fatal(err)
+    }
+    got := polled(t, b, dir)
+    if len(got) != 2 || got[0] != "WRITE new.mp4" || got[1] != "WRITE old.mp4" {
+        t.Errorf("written: %q", got)
+    }
+
+    if err := os.Remove(old); err != nil {
+        t.Fatal(err)
+    }
+    if got := polled(t, b, dir); len(got) != 1 || got[0] != "REMOVE old.mp4" {
+        t.Errorf("removed: %q", got)
+    }
+
+    // Unchanged files and folders are not reported
+    writeFile(t, filepath.Join(dir, "sub", "inner.mp4"))
+    if got := polled(t, b, dir); len(got) != 0 {
+        t.Errorf("unchanged: %q", got)
+    }
+}
+
+func TestPollKeepsStateWhileUnreachable(t *testing.T) {
+    b, dir := newPolledDir(t)
+    hidden := dir + ".hidden"
+    if err := os.Rename(dir, hidden); err != nil {
+        t.Fatal(err)
+    }
+
+    got := polled(t, b, dir)
+    if len(got) != 1 || !strings.HasPrefix(got[0], "error ") {
+        t.Errorf("unreachable: %q, want one error", got)
+    }
+
+    // Back again, nothing looks new or removed
+    if err := os.Rename(hidden, dir); err != nil {
+        t.Fatal(err)
+    }
+    if got := polled(t, b, dir); len(got) != 0 {
+        t.Errorf("reachable again: %q", got)
+    }
+}
+
+func TestPollRemovedDirIsNotReported(t *testing.T) {
+    b, dir := newPolledDir(t)
+    if err := b.Remove(dir); err != nil {
+        t.Fatal(err)
+    }
+    writeFile(t, filepath.Join(dir, "new.mp4"))
+    if got := polled(t, b, dir); len(got) != 0 {
+        t.Errorf("after Remove: %q", got)
+    }
+}
+
+func TestPollBackendRuns(t *testing.T) {
+    dir := t.TempDir()
+    b := newPollBackend(20 * time.Millisecond)
+    if err := b.Add(dir); err != nil {
+        t.Fatal(err)
+    }
+
+    path := filepath.Join(dir, "new.mp4")
+    writeFile(t, path)
+    select {
+    case event := <-b.Events():
+        if event.Name != path || !event.Has(fsnotify.Create) {
+            t.Errorf("event = %v, want a create of %s", event, path)
+        }
+    case <-time.After(2 * time.Second):
+        t.Fatal("no event for a new file")
+    }
+
+    // Closing ends the event stream
+    b.Close()
+    select {
+    case _, ok := <-b.Events():
+        if ok {
+            t.Error("event after Close")
+        }
+    case <-time.After(2 * time.Second):
+        t.Fatal("events not closed")
+    }
+}
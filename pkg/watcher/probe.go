@@ -0,0 +1,113 @@
+package watcher
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "os/exec"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+)
+
+// MediaInfo is what ffprobe reports about a video file
+type MediaInfo struct {
+    Duration   time.Duration
+    Width      int
+    Height     int
+    VideoCodec string
+    Container  string
+}
+
+// RejectError marks a file that failed validation or can never be uploaded,
+// and must not be retried
+type RejectError struct {
+    Reason string
+}
+
+func (e *RejectError) Error() string {
+    return "rejected: " + e.Reason
+}
+
+type ffprobeOutput struct {
+    Streams []struct {
+        CodecType string `json:"codec_type"`
+        CodecName string `json:"codec_name"`
+        Width     int    `json:"width"`
+        Height    int    `json:"height"`
+    } `json:"streams"`
+    Format struct {
+        FormatName string `json:"format_name"`
+        Duration   string `json:"duration"`
+    } `json:"format"`
+}
+
+// probeFile runs ffprobe on path and checks the result against the
+// validation settings. Bad files are reported as *RejectError.
+func probeFile(ctx context.Context, cfg config.ValidationConfig, path string) (*MediaInfo, error) {
+    ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout)*time.Second)
+    defer cancel()
+
+    var stdout, stderr bytes.Buffer
+    cmd := exec.CommandContext(ctx, cfg.FFprobePath,
+        "-v", "error",
+        "-print_format", "json",
+        "-show_format",
+        "-show_streams",
+        path,
+    )
+    cmd.Stdout = &stdout
+    cmd.Stderr = &stderr
+
+    if err := cmd.Run(); err != nil {
+        if ctx.Err() == context.Canceled {
+            return nil, ctx.Err()
+        }
+        if ctx.Err() == context.DeadlineExceeded {
+            return nil, fmt.Errorf("ffprobe timed out after %ds", cfg.Timeout)
+        }
+        if _, ok := err.(*exec.ExitError); ok {
+            return nil, &RejectError{Reason: "unreadable container: " + strings.TrimSpace(stderr.String())}
+        }
+        return nil, fmt.Errorf("running ffprobe: %w", err)
+    }
+
+    var out ffprobeOutput
+    if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+        return nil, fmt.Errorf("parsing ffprobe output: %w", err)
+    }
+
+    info := &MediaInfo{Container: out.Format.FormatName}
+
+    hasVideo := false
+    for _, stream := range out.Streams {
+        if stream.CodecType == "video" {
+            hasVideo = true
+            info.Width = stream.Width
+            info.Height = stream.Height
+            info.VideoCodec = stream.CodecName
+            break
+        }
+    }
+    if !hasVideo {
+        return nil, &RejectError{Reason: "no video stream"}
+    }
+
+    seconds, err := strconv.ParseFloat(out.Format.Duration, 64)
+    if err != nil || seconds <= 0 {
+        return nil, &RejectError{Reason: "unknown duration (file may be truncated)"}
+    }
+    info.Duration = time.Duration(seconds * float64(time.Second))
+
+    if seconds < cfg.MinDuration {
+        return nil, &RejectError{Reason: fmt.Sprintf("duration %.1fs is shorter than %.1fs", seconds, cfg.MinDuration)}
+    }
+    if cfg.MaxDuration > 0 && seconds > cfg.MaxDuration {
+        return nil, &RejectError{Reason: fmt.Sprintf("duration %.1fs is longer than %.1fs", seconds, cfg.MaxDuration)}
+    }
+
+    return info, nil
+}
@@ -0,0 +1,229 @@
+package watcher
+
+import (
+    "context"
+    "errors"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "runtime"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/dsu-teknik/peertube-monitor/pkg/config"
+)
+
+// fakeFFprobe is a stand-in for ffprobe: it prints the contents of
+// <file>.probe, or fails like ffprobe does on a file it can't read
+const fakeFFprobe = `#!/bin/sh
+for file; do :; done
+if [ -f "$file.sleep" ]; then
+    exec sleep 5
+fi
+if [ ! -f "$file.probe" ]; then
+    echo "$file: Invalid data found when processing input" >&2
+    exit 1
+fi
+cat "$file.probe"
+`
+
+const (
+    probeVideo = `{"streams": [{"codec_type": "audio", "codec_name": "aac"},
+        {"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080}],
+        "format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "%s"}}`
+    probeAudioOnly = `{"streams": [{"codec_type": "audio", "codec_name": "mp3"}],
+        "format": {"format_name": "mp3", "duration": "120.0"}}`
+)
+
+// probeSetup writes the fake ffprobe and returns validation settings using it
+func probeSetup(t *testing.T) (config.ValidationConfig, string) {
+    if runtime.GOOS == "windows" {
+        t.Skip("fake ffprobe is a shell script")
+    }
+    dir := t.TempDir()
+    ffprobe := filepath.Join(dir, "ffprobe")
+    if err := os.WriteFile(ffprobe, []byte(fakeFFprobe), 0755); err != nil {
+        t.Fatal(err)
+    }
+    cfg := config.ValidationConfig{
+        Enabled:     true,
+        FFprobePath: ffprobe,
+        MinDuration: 1,
+        Timeout:     10,
+    }
+    return cfg, dir
+}
+
+// videoFile creates a file that the fake ffprobe reports with probe output
+func videoFile(t *testing.T, dir, name, probe string) string {
+    path := filepath.Join(dir, name)
+    if err := os.WriteFile(path, []byte("not really a video"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if probe != "" {
+        if err := os.WriteFile(path+".probe", []byte(probe), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return path
+}
+
+func withDuration(seconds string) string {
+    return strings.Replace(probeVideo, "%s", seconds, 1)
+}
+
+func TestProbeFileAccepts(t *testing.T) {
+    cfg, dir := probeSetup(t)
+    path := videoFile(t, dir, "clip.mp4", withDuration("61.5"))
+
+    info, err := probeFile(context.Background(), cfg, path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if info.Width != 1920 || info.Height != 1080 || info.VideoCodec != "h264" {
+        t.Errorf("unexpected video stream: %+v", info)
+    }
+    if info.Duration != 61500*time.Millisecond {
+        t.Errorf("duration = %s", info.Duration)
+    }
+    if !strings.HasPrefix(info.Container, "mov,mp4") {
+        t.Errorf("container = %q", info.Container)
+    }
+}
+
+func TestProbeFileRejects(t *testing.T) {
+    cfg, dir := probeSetup(t)
+    cfg.MinDuration = 5
+    cfg.MaxDuration = 600
+
+    tests := []struct {
+        name   string
+        probe  string
+        reason string
+    }{
+        {"audio.mp4", probeAudioOnly, "no video stream"},
+        {"broken.mp4", "", "unreadable container: "},
+        {"truncated.mp4", withDuration("N/A"), "unknown duration"},
+        {"empty.mp4", withDuration("0.000000"), "unknown duration"},
+        {"short.mp4", withDuration("2.5"), "duration 2.5s is shorter than 5.0s"},
+        {"long.mp4", withDuration("3600"), "duration 3600.0s is longer than 600.0s"},
+    }
+    for _, tt := range tests {
+        path := videoFile(t, dir, tt.name, tt.probe)
+        _, err := probeFile(context.Background(), cfg, path)
+
+        var rejectErr *RejectError
+        if !errors.As(err, &rejectErr) {
+            t.Errorf("%s: err = %v, want a *RejectError", tt.name, err)
+            continue
+        }
+        if !strings.HasPrefix(rejectErr.Reason, tt.reason) {
+            t.Errorf("%s: reason = %q, want %q", tt.name, rejectErr.Reason, tt.reason)
+        }
+    }
+}
+
+func TestProbeFileDurationBounds(t *testing.T) {
+    cfg, dir := probeSetup(t)
+    cfg.MinDuration = 5
+    cfg.MaxDuration = 600
+
+    // The bounds themselves are allowed
+    for _, seconds := range []string{"5", "600"} {
+        path := videoFile(t, dir, "bound"+seconds+".mp4", withDuration(seconds))
+        if _, err := probeFile(context.Background(), cfg, path); err != nil {
+            t.Errorf("%ss: %v", seconds, err)
+        }
+    }
+
+    // No upper bound when maxDuration is 0
+    cfg.MaxDuration = 0
+    path := videoFile(t, dir, "day.mp4", withDuration("86400"))
+    if _, err := probeFile(context.Background(), cfg, path); err != nil {
+        t.Errorf("no max duration: %v", err)
+    }
+}
+
+func TestProbeFileErrorsAreNotRejections(t *testing.T) {
+    cfg, dir := probeSetup(t)
+
+    // A missing ffprobe is a setup problem, not a bad file
+    missing := cfg
+    missing.FFprobePath = filepath.Join(dir, "no-such-ffprobe")
+    path := videoFile(t, dir, "clip.mp4", withDuration("10"))
+    _, err := probeFile(context.Background(), missing, path)
+    var rejectErr *RejectError
+    if err == nil || errors.As(err, &rejectErr) {
+        t.Errorf("missing ffprobe: err = %v, want a plain error", err)
+    }
+
+    // So is ffprobe hanging
+    cfg.Timeout = 1
+    slow := videoFile(t, dir, "slow.mp4", withDuration("10"))
+    if err := os.WriteFile(slow+".sleep", nil, 0644); err != nil {
+        t.Fatal(err)
+    }
+    _, err = probeFile(context.Background(), cfg, slow)
+    if err == nil || errors.As(err, &rejectErr) || !strings.Contains(err.Error(), "timed out") {
+        t.Errorf("slow ffprobe: err = %v, want a timeout", err)
+    }
+}
+
+func TestHandleFileWritesReasonFile(t *testing.T) {
+    validation, dir := probeSetup(t)
+
+    watch := filepath.Join(dir, "watch")
+    failed := filepath.Join(dir, "failed")
+    for _, d := range []string{watch, failed} {
+        if err := os.Mkdir(d, 0755); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    tests := []struct {
+        name       string
+        failedPath string
+        probe      string
+        dest       string
+        reason     string
+    }{
+        {"audio.mp4", failed, probeAudioOnly, filepath.Join(failed, "audio.mp4"), "no video stream"},
+        {"short.mp4", failed, withDuration("0.5"), filepath.Join(failed, "short.mp4"), "duration 0.5s is shorter than 1.0s"},
+        {"broken.mp4", "", "", filepath.Join(watch, "broken.mp4.failed"), "unreadable container"},
+    }
+    for _, tt := range tests {
+        cfg := &config.Config{}
+        cfg.Watcher.WatchPath = watch
+        cfg.Watcher.FailedPath = tt.failedPath
+        cfg.Watcher.MaxRetries = 3
+        cfg.Watcher.Validation = validation
+        h := NewUploadHandler(nil, cfg, nil, log.New(io.Discard, "", 0))
+
+        path := videoFile(t, watch, tt.name, tt.probe)
+        result, err := h.HandleFile(context.Background(), path)
+        if err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+            continue
+        }
+        if result.Status != StatusFailed {
+            t.Errorf("%s: status = %s, want %s", tt.name, result.Status, StatusFailed)
+        }
+
+        if _, err := os.Stat(path); !os.IsNotExist(err) {
+            t.Errorf("%s: file still in the watch folder", tt.name)
+        }
+        if _, err := os.Stat(tt.dest); err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+        }
+        reason, err := os.ReadFile(tt.dest + ".reason.txt")
+        if err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+            continue
+        }
+        if !strings.HasPrefix(string(reason), tt.reason) || !strings.HasSuffix(string(reason), "\n") {
+            t.Errorf("%s: reason file = %q, want %q", tt.name, reason, tt.reason)
+        }
+    }
+}
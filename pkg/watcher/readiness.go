@@ -0,0 +1,62 @@
+package watcher
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// Readiness selects the checks a file must pass, after it has stopped
+// changing, before it is handled. All enabled checks must pass.
+type Readiness struct {
+    StableChecks int           // settle periods in a row without changes (at least 1)
+    NotInUse     bool          // no other process has the file open
+    MarkerSuffix string        // wait for a marker file named after the video plus this, e.g. ".done"
+    MinAge       time.Duration // time since the last modification
+}
+
+// SetReadiness replaces the readiness checks. Files already queued are
+// checked with the new settings from their next check on.
+func (w *Watcher) SetReadiness(r Readiness) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    w.readiness = r
+}
+
+// check returns why a file that has been unchanged for stable settle
+// periods is not ready yet, and when to check again. An empty reason means
+// the file is ready. indefinite is set when there is no telling how long
+// the wait will be.
+func (r Readiness) check(path string, info os.FileInfo, stable int, settle time.Duration) (reason string, wait time.Duration, indefinite bool) {
+    if stable < r.StableChecks {
+        return fmt.Sprintf("waiting for %d checks without changes", r.StableChecks), settle, false
+    }
+    if age := time.Since(info.ModTime()); age < r.MinAge {
+        return fmt.Sprintf("modified less than %s ago", r.MinAge), r.MinAge - age, false
+    }
+    if r.MarkerSuffix != "" {
+        if _, err := os.Stat(path + r.MarkerSuffix); err != nil {
+            return fmt.Sprintf("waiting for marker file %s", filepath.Base(path+r.MarkerSuffix)), settle, true
+        }
+    }
+    if r.NotInUse && inUse(path) {
+        return "open in another process", settle, true
+    }
+    return "", 0, false
+}
+
+// removeMarker deletes the marker file once the video has left the watch
+// folder
+func (r Readiness) removeMarker(path string) error {
+    if r.MarkerSuffix == "" {
+        return nil
+    }
+    if _, err := os.Stat(path); !os.IsNotExist(err) {
+        return nil
+    }
+    if err := os.Remove(path + r.MarkerSuffix); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    return nil
+}
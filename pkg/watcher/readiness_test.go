@@ -0,0 +1,97 @@
+package watcher
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestReadinessCheck(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "video.mp4")
+    writeFile(t, path)
+    marked := filepath.Join(dir, "marked.mp4")
+    writeFile(t, marked)
+    writeFile(t, marked+".done")
+
+    // The video was last modified a minute ago
+    minuteAgo := time.Now().Add(-time.Minute)
+    for _, p := range []string{path, marked} {
+        if err := os.Chtimes(p, minuteAgo, minuteAgo); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    const settle = 5 * time.Second
+    tests := []struct {
+        name       string
+        r          Readiness
+        path       string
+        stable     int
+        reason     string // prefix, "" = ready
+        wait       time.Duration
+        indefinite bool
+    }{
+        {"ready", Readiness{StableChecks: 1}, path, 1, "", 0, false},
+        {"not stable long enough", Readiness{StableChecks: 3}, path, 2, "waiting for 3 checks without changes", settle, false},
+        {"stable long enough", Readiness{StableChecks: 3}, path, 3, "", 0, false},
+        {"old enough", Readiness{StableChecks: 1, MinAge: 30 * time.Second}, path, 1, "", 0, false},
+        {"too new", Readiness{StableChecks: 1, MinAge: 2 * time.Minute}, path, 1, "modified less than 2m0s ago", time.Minute, false},
+        {"no marker", Readiness{StableChecks: 1, MarkerSuffix: ".done"}, path, 1, "waiting for marker file video.mp4.done", settle, true},
+        {"marker", Readiness{StableChecks: 1, MarkerSuffix: ".done"}, marked, 1, "", 0, false},
+        {"stability first", Readiness{StableChecks: 2, MinAge: 2 * time.Minute, MarkerSuffix: ".done"}, path, 1, "waiting for 2 checks", settle, false},
+        {"age before marker", Readiness{StableChecks: 1, MinAge: 2 * time.Minute, MarkerSuffix: ".done"}, path, 1, "modified less than", time.Minute, false},
+        {"not in use", Readiness{StableChecks: 1, NotInUse: true}, path, 1, "", 0, false},
+    }
+    for _, tt := range tests {
+        info, err := os.Stat(tt.path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        reason, wait, indefinite := tt.r.check(tt.path, info, tt.stable, settle)
+        if tt.reason == "" && reason != "" || !strings.HasPrefix(reason, tt.reason) {
+            t.Errorf("%s: reason = %q, want %q", tt.name, reason, tt.reason)
+        }
+        // The wait for the minimum age shrinks while the test runs
+        if wait > tt.wait || wait < tt.wait-5*time.Second {
+            t.Errorf("%s: wait = %s, want %s", tt.name, wait, tt.wait)
+        }
+        if indefinite != tt.indefinite {
+            t.Errorf("%s: indefinite = %v", tt.name, indefinite)
+        }
+    }
+}
+
+func TestReadinessRemoveMarker(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "video.mp4")
+    marker := path + ".done"
+    writeFile(t, path)
+    writeFile(t, marker)
+    r := Readiness{MarkerSuffix: ".done"}
+
+    // Kept while the video is still there
+    if err := r.removeMarker(path); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.Stat(marker); err != nil {
+        t.Errorf("marker removed while the video is in the watch folder: %v", err)
+    }
+
+    if err := os.Remove(path); err != nil {
+        t.Fatal(err)
+    }
+    if err := r.removeMarker(path); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.Stat(marker); !os.IsNotExist(err) {
+        t.Error("marker not removed after the video left")
+    }
+
+    // A marker already gone is fine
+    if err := r.removeMarker(path); err != nil {
+        t.Errorf("second removal: %v", err)
+    }
+}
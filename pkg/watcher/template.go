@@ -0,0 +1,68 @@
+package watcher
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "text/template"
+    "time"
+)
+
+const defaultNameTemplate = "{{.Basename}}"
+
+// templateData is available to the name and description templates
+type templateData struct {
+    Path     string
+    Filename string
+    Basename string
+    Ext      string
+    Size     int64
+    ModTime  time.Time
+
+    // Only set when media validation is enabled
+    Duration   time.Duration
+    Width      int
+    Height     int
+    Resolution string
+    VideoCodec string
+}
+
+func newTemplateData(path string, media *MediaInfo) templateData {
+    filename := filepath.Base(path)
+    data := templateData{
+        Path:     path,
+        Filename: filename,
+        Basename: strings.TrimSuffix(filename, filepath.Ext(filename)),
+        Ext:      filepath.Ext(filename),
+    }
+
+    if info, err := os.Stat(path); err == nil {
+        data.Size = info.Size()
+        data.ModTime = info.ModTime()
+    }
+
+    if media != nil {
+        data.Duration = media.Duration.Round(time.Second)
+        data.Width = media.Width
+        data.Height = media.Height
+        data.Resolution = fmt.Sprintf("%dx%d", media.Width, media.Height)
+        data.VideoCodec = media.VideoCodec
+    }
+
+    return data
+}
+
+func renderTemplate(name, text string, data templateData) (string, error) {
+    tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+    if err != nil {
+        return "", fmt.Errorf("parsing %s template: %w", name, err)
+    }
+
+    var buf bytes.Buffer
+    if err := tmpl.Execute(&buf, data); err != nil {
+        return "", fmt.Errorf("rendering %s template: %w", name, err)
+    }
+    return buf.String(), nil
+}
@@ -1,28 +1,75 @@
 package watcher
 
 import (
+    "context"
+    "errors"
     "fmt"
     "log"
     "os"
     "path/filepath"
-    "strings"
+    "sync"
     "time"
 
     "github.com/fsnotify/fsnotify"
 )
 
+// FileHandler processes a settled file. The context is cancelled when the
+// watcher stops; the handler should then abort and leave the file in place.
 type FileHandler interface {
-    HandleFile(path string) error
+    HandleFile(ctx context.Context, path string) (*Result, error)
 }
 
+// DeferError is returned by a FileHandler to keep a file queued and try it
+// again after RetryAfter. Attempt is set when this is a retry after a failed
+// attempt, and zero when the file is only held (e.g. outside upload windows).
+type DeferError struct {
+    Reason     string
+    RetryAfter time.Duration
+    Attempt    int
+}
+
+func (e *DeferError) Error() string {
+    return "deferred: " + e.Reason
+}
+
+// Statuses of files in a RunOnce summary besides the Result statuses
+const (
+    StatusHeld        = "held"        // deferred to a later run
+    StatusInterrupted = "interrupted" // cancelled by shutdown
+)
+
+// Summary lists the files of a RunOnce by status
+type Summary map[string][]string
+
 type Watcher struct {
     watchPath       string
     extensions      []string
     settleTime      time.Duration
     handler         FileHandler
-    fsWatcher       *fsnotify.Watcher
+    backend         backend
+    backendKind     string // the resolved kind, "fsnotify" or "poll"
+    backendConfig   Backend
+    readiness       Readiness
+    filter          Filter
+    rejected        map[string]string // files left alone, with the reason last logged
+    holding         map[string]string // files held by the handler, with the reason last logged
     pendingFiles    map[string]*fileState
+    mu              sync.Mutex
     logger          *log.Logger
+
+    // ctx is cancelled on shutdown to abort in-flight uploads
+    ctx         context.Context
+    cancel      context.CancelFunc
+    active      sync.WaitGroup
+    activeFiles map[string]bool
+    stopping    bool
+    stopOnce    sync.Once
+
+    // once is set by RunOnce: held files are not rescheduled and outcomes
+    // are recorded in summary; changed is signalled when a file is done
+    once    bool
+    summary Summary
+    changed chan struct{}
 }
 
 type fileState struct {
@@ -30,29 +77,55 @@ type fileState struct {
     lastModified time.Time
     size         int64
     timer        *time.Timer
+    held         bool   // deferred by the handler rather than settling
+    stable       int    // settle periods in a row without changes
+    waiting      string // why the settled file is not ready, last logged
 }
 
-func New(watchPath string, extensions []string, settleTime int, handler FileHandler, logger *log.Logger) (*Watcher, error) {
-    fsWatcher, err := fsnotify.NewWatcher()
+func New(watchPath string, extensions []string, settleTime int, backendConfig Backend, handler FileHandler, logger *log.Logger) (*Watcher, error) {
+    kind, err := resolveKind(backendConfig.Kind, watchPath)
     if err != nil {
-        return nil, fmt.Errorf("creating fsnotify watcher: %w", err)
+        return nil, err
+    }
+    b, err := newBackend(kind, backendConfig.PollInterval)
+    if err != nil {
+        return nil, err
     }
 
+    ctx, cancel := context.WithCancel(context.Background())
     w := &Watcher{
-        watchPath:    watchPath,
-        extensions:   extensions,
-        settleTime:   time.Duration(settleTime) * time.Second,
-        handler:      handler,
-        fsWatcher:    fsWatcher,
-        pendingFiles: make(map[string]*fileState),
-        logger:       logger,
+        ctx:           ctx,
+        cancel:        cancel,
+        watchPath:     watchPath,
+        extensions:    extensions,
+        settleTime:    time.Duration(settleTime) * time.Second,
+        handler:       handler,
+        backend:       b,
+        backendKind:   kind,
+        backendConfig: backendConfig,
+        readiness:     Readiness{StableChecks: 1},
+        rejected:      make(map[string]string),
+        holding:       make(map[string]string),
+        pendingFiles:  make(map[string]*fileState),
+        activeFiles:   make(map[string]bool),
+        changed:       make(chan struct{}, 1),
+        logger:        logger,
     }
 
-    if err := fsWatcher.Add(watchPath); err != nil {
+    if err := b.Add(watchPath); err != nil {
+        cancel()
+        b.Close()
         return nil, fmt.Errorf("watching path %s: %w", watchPath, err)
     }
 
-    w.logger.Printf("Watching: %s", watchPath)
+    if kind == "poll" {
+        if backendConfig.Kind == "auto" {
+            w.logger.Printf("%s is on a network filesystem; polling for changes", watchPath)
+        }
+        w.logger.Printf("Watching: %s (polling every %s)", watchPath, backendConfig.PollInterval)
+    } else {
+        w.logger.Printf("Watching: %s", watchPath)
+    }
     return w, nil
 }
 
@@ -65,33 +138,211 @@ func (w *Watcher) Start() error {
     // Watch for new files
     for {
         select {
-        case event, ok := <-w.fsWatcher.Events:
+        case event, ok := <-w.backend.Events():
             if !ok {
                 return nil
             }
             w.handleEvent(event)
 
-        case err, ok := <-w.fsWatcher.Errors:
+        case err, ok := <-w.backend.Errors():
             if !ok {
                 return nil
             }
-            w.logger.Printf("Watcher error: %v", err)
+            w.logger.Printf("ERROR: Watcher error: %v", err)
+        }
+    }
+}
+
+// RunOnce processes the files already in the watch folder, without watching
+// for new ones, and returns when each has been uploaded, given up on, or
+// held for a later run. Failed uploads are retried as usual.
+func (w *Watcher) RunOnce() (Summary, error) {
+    w.mu.Lock()
+    w.once = true
+    w.summary = make(Summary)
+    w.mu.Unlock()
+
+    if err := w.scanExisting(); err != nil {
+        return nil, fmt.Errorf("scanning existing files: %w", err)
+    }
+
+    for {
+        w.mu.Lock()
+        idle := len(w.pendingFiles) == 0 && len(w.activeFiles) == 0
+        w.mu.Unlock()
+        if idle {
+            break
         }
+        <-w.changed
+    }
+
+    // Nothing is running anymore, so this only releases the watcher
+    w.Shutdown(0)
+
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    return w.summary, nil
+}
+
+// record notes the outcome of a file in RunOnce mode
+func (w *Watcher) record(path, status string) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    if w.once {
+        w.summary[status] = append(w.summary[status], path)
     }
 }
 
+// signalChanged wakes RunOnce after a file leaves the queue
+func (w *Watcher) signalChanged() {
+    select {
+    case w.changed <- struct{}{}:
+    default:
+    }
+}
+
+// Stop stops watching and cancels in-flight uploads immediately
 func (w *Watcher) Stop() {
-    w.fsWatcher.Close()
+    w.Shutdown(0)
+}
+
+// Shutdown stops watching and cancels pending checks, then waits up to
+// timeout for uploads in progress to finish. Uploads still running after
+// that are cancelled and their paths returned. Interrupted files stay in
+// the watch folder and are picked up again on the next start.
+func (w *Watcher) Shutdown(timeout time.Duration) []string {
+    w.stopOnce.Do(func() {
+        w.mu.Lock()
+        w.stopping = true
+        for path, state := range w.pendingFiles {
+            if state.timer != nil {
+                state.timer.Stop()
+            }
+            delete(w.pendingFiles, path)
+        }
+        w.mu.Unlock()
+
+        w.backend.Close()
+        w.signalChanged()
+    })
+
+    done := make(chan struct{})
+    go func() {
+        w.active.Wait()
+        close(done)
+    }()
+
+    var interrupted []string
+    select {
+    case <-done:
+    case <-time.After(timeout):
+        w.mu.Lock()
+        for path := range w.activeFiles {
+            interrupted = append(interrupted, path)
+        }
+        w.mu.Unlock()
+
+        w.cancel()
+        <-done
+    }
+
+    w.cancel()
+    return interrupted
+}
+
+// RetryHeld tries the files held by the handler again now rather than when
+// their wait is over, e.g. once the server can be reached again
+func (w *Watcher) RetryHeld() {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    for path, state := range w.pendingFiles {
+        if state.held && state.timer != nil && state.timer.Stop() {
+            path := path
+            state.timer = time.AfterFunc(0, func() {
+                w.processFile(path)
+            })
+        }
+    }
+}
+
+// ActiveUploads returns the number of files currently being processed
+func (w *Watcher) ActiveUploads() int {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    return len(w.activeFiles)
+}
+
+// Reconfigure changes the watched folder, extensions and settle time of a
+// running watcher. When the folder changes, files queued from the old one
+// are dropped and the new one is scanned for existing files. Uploads in
+// progress are not affected.
+func (w *Watcher) Reconfigure(watchPath string, extensions []string, settleTime int) error {
+    w.mu.Lock()
+    oldPath := w.watchPath
+    w.mu.Unlock()
+
+    if watchPath != oldPath {
+        // The backend can't be swapped while running
+        if kind, err := resolveKind(w.backendConfig.Kind, watchPath); err == nil && kind != w.backendKind {
+            w.logger.Printf("WARNING: %s would be watched with %s rather than %s; restart the service to switch", watchPath, kind, w.backendKind)
+        }
+        if err := w.backend.Add(watchPath); err != nil {
+            return fmt.Errorf("watching path %s: %w", watchPath, err)
+        }
+        if err := w.backend.Remove(oldPath); err != nil {
+            w.logger.Printf("ERROR: Could not remove watch on %s: %v", oldPath, err)
+        }
+    }
+
+    w.mu.Lock()
+    w.watchPath = watchPath
+    w.extensions = extensions
+    w.settleTime = time.Duration(settleTime) * time.Second
+    if watchPath != oldPath {
+        w.rejected = make(map[string]string)
+        w.holding = make(map[string]string)
+        for path, state := range w.pendingFiles {
+            if filepath.Dir(path) != filepath.Clean(watchPath) {
+                if state.timer != nil {
+                    state.timer.Stop()
+                }
+                delete(w.pendingFiles, path)
+            }
+        }
+    }
+    w.mu.Unlock()
+
+    if watchPath == oldPath {
+        return nil
+    }
+
+    w.logger.Printf("Watching: %s (was %s)", watchPath, oldPath)
+    return w.scanExisting()
 }
 
 func (w *Watcher) handleEvent(event fsnotify.Event) {
-    // Only process video files
-    if !w.isVideoFile(event.Name) {
+    // Only process video files in the current watch folder
+    if !w.isWatched(event.Name) {
+        return
+    }
+    gone := event.Op&(fsnotify.Remove|fsnotify.Rename) != 0
+    if gone {
+        w.mu.Lock()
+        delete(w.rejected, event.Name)
+        w.mu.Unlock()
+    }
+    if ok, reason := w.matchName(event.Name); !ok {
+        if reason != "" && !gone {
+            w.reject(event.Name, reason)
+        }
         return
     }
 
     switch {
     case event.Op&fsnotify.Create == fsnotify.Create:
+        // Also sent for the new name when a file is renamed or moved into
+        // the folder
         w.logger.Printf("New file detected: %s", event.Name)
         w.scheduleFileCheck(event.Name)
 
@@ -99,22 +350,63 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
         // File is being written, reschedule check
         w.scheduleFileCheck(event.Name)
 
-    case event.Op&fsnotify.Remove == fsnotify.Remove:
-        // File was removed, cancel processing
-        if state, exists := w.pendingFiles[event.Name]; exists {
-            if state.timer != nil {
-                state.timer.Stop()
-            }
-            delete(w.pendingFiles, event.Name)
-            w.logger.Printf("File removed before processing: %s", event.Name)
+    case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+        // File was removed, or renamed or moved away (sent for the old
+        // name), cancel processing
+        if w.forget(event.Name) {
+            w.logger.Printf("File removed or renamed before processing: %s", event.Name)
+            w.signalChanged()
         }
     }
 }
 
+// forget drops a file from the queue and cancels its pending check. It
+// reports whether the file was queued.
+func (w *Watcher) forget(path string) bool {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    delete(w.holding, path)
+    state, exists := w.pendingFiles[path]
+    if !exists {
+        return false
+    }
+    if state.timer != nil {
+        state.timer.Stop()
+    }
+    delete(w.pendingFiles, path)
+    return true
+}
+
 func (w *Watcher) scheduleFileCheck(path string) {
+    w.mu.Lock()
+    settleTime := w.settleTime
+    w.mu.Unlock()
+
+    w.scheduleFileCheckAfter(path, settleTime)
+}
+
+// scheduleFileCheckAfter records the file's current size and modification
+// time and checks again after delay whether it has settled
+func (w *Watcher) scheduleFileCheckAfter(path string, delay time.Duration) {
     info, err := os.Stat(path)
     if err != nil {
-        w.logger.Printf("Error stating file %s: %v", path, err)
+        // Without a timer the file would stay queued forever, and RunOnce
+        // would never finish
+        if os.IsNotExist(err) {
+            w.logger.Printf("File removed or renamed before processing: %s", path)
+        } else {
+            w.logger.Printf("ERROR: Could not stat file %s: %v", path, err)
+        }
+        w.forget(path)
+        w.signalChanged()
+        return
+    }
+
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    if w.stopping {
         return
     }
 
@@ -128,17 +420,24 @@ func (w *Watcher) scheduleFileCheck(path string) {
         w.pendingFiles[path] = state
     }
 
+    if !info.ModTime().Equal(state.lastModified) || info.Size() != state.size {
+        state.stable = 0
+        state.waiting = ""
+    }
     state.lastModified = info.ModTime()
     state.size = info.Size()
+    state.held = false
 
     // Schedule file processing after settle time
-    state.timer = time.AfterFunc(w.settleTime, func() {
+    state.timer = time.AfterFunc(delay, func() {
         w.processFile(path)
     })
 }
 
 func (w *Watcher) processFile(path string) {
+    w.mu.Lock()
     state, exists := w.pendingFiles[path]
+    w.mu.Unlock()
     if !exists {
         return
     }
@@ -146,8 +445,13 @@ func (w *Watcher) processFile(path string) {
     // Verify file hasn't changed
     info, err := os.Stat(path)
     if err != nil {
-        w.logger.Printf("Error checking file %s: %v", path, err)
-        delete(w.pendingFiles, path)
+        if os.IsNotExist(err) {
+            w.logger.Printf("File removed or renamed before processing: %s", path)
+        } else {
+            w.logger.Printf("ERROR: Could not check file %s: %v", path, err)
+        }
+        w.forget(path)
+        w.signalChanged()
         return
     }
 
@@ -158,19 +462,145 @@ func (w *Watcher) processFile(path string) {
         return
     }
 
+    // Unchanged for another settle period; check the other conditions
+    w.mu.Lock()
+    state.stable++
+    stable, readiness, filter, settleTime := state.stable, w.readiness, w.filter, w.settleTime
+    w.mu.Unlock()
+
+    // Downloaders may create the final name as a placeholder while they
+    // write to a temporary file, and rename it over the placeholder when done
+    if temp := temporaryFile(path); temp != "" {
+        w.waitUntilReady(path, state, "still being written to "+filepath.Base(temp), settleTime, true)
+        return
+    }
+
+    if reason, wait, indefinite := readiness.check(path, info, stable, settleTime); reason != "" {
+        w.waitUntilReady(path, state, reason, wait, indefinite)
+        return
+    }
+
+    // Size and content are only checked once the file is complete
+    reason, err := filter.check(path, info)
+    if err != nil {
+        w.logger.Printf("ERROR: Could not check file %s: %v", path, err)
+        w.scheduleFileCheck(path)
+        return
+    }
+    if reason != "" {
+        w.mu.Lock()
+        delete(w.pendingFiles, path)
+        w.mu.Unlock()
+        w.reject(path, reason)
+        w.record(path, StatusSkipped)
+        w.signalChanged()
+        return
+    }
+
     // File is ready, process it
+    w.mu.Lock()
+    if w.stopping {
+        w.mu.Unlock()
+        return
+    }
+    once := w.once
     delete(w.pendingFiles, path)
-    w.logger.Printf("Processing file: %s", path)
+    delete(w.rejected, path)
+    w.activeFiles[path] = true
+    w.active.Add(1)
+    w.mu.Unlock()
+
+    defer func() {
+        w.mu.Lock()
+        delete(w.activeFiles, path)
+        w.mu.Unlock()
+        w.active.Done()
+        w.signalChanged()
+    }()
+
+    result, err := w.handler.HandleFile(w.ctx, path)
+    if w.ctx.Err() != nil {
+        w.record(path, StatusInterrupted)
+        return
+    }
+
+    var deferErr *DeferError
+    if errors.As(err, &deferErr) {
+        if once && deferErr.Attempt == 0 {
+            // Leave the file for the next run
+            w.logger.Printf("Holding %s for the next run: %s", path, deferErr.Reason)
+            w.record(path, StatusHeld)
+            return
+        }
+
+        // Keep the file queued and try again later. A file held for the
+        // same reason again, e.g. while uploads are paused, is logged once.
+        w.mu.Lock()
+        logged := w.holding[path] == deferErr.Reason
+        w.holding[path] = deferErr.Reason
+        w.mu.Unlock()
+        if !logged {
+            w.logger.Printf("Holding %s: %s (next attempt in %s)", path, deferErr.Reason, deferErr.RetryAfter)
+        }
+        w.scheduleFileCheckAfter(path, deferErr.RetryAfter)
+        w.mu.Lock()
+        if state, ok := w.pendingFiles[path]; ok {
+            state.held = true
+        }
+        w.mu.Unlock()
+        return
+    }
+    w.mu.Lock()
+    delete(w.holding, path)
+    w.mu.Unlock()
+    if err != nil {
+        w.logger.Printf("ERROR: Could not handle file %s: %v", path, err)
+        w.record(path, StatusFailed)
+        return
+    }
+    if result != nil {
+        w.record(path, result.Status)
+    }
+    if err := readiness.removeMarker(path); err != nil {
+        w.logger.Printf("WARNING: Could not remove marker file for %s: %v", path, err)
+    }
+}
 
-    if err := w.handler.HandleFile(path); err != nil {
-        w.logger.Printf("Error handling file %s: %v", path, err)
+// waitUntilReady checks a settled file that is not ready yet again after
+// wait. In RunOnce mode a file that may wait indefinitely is held for the
+// next run instead.
+func (w *Watcher) waitUntilReady(path string, state *fileState, reason string, wait time.Duration, indefinite bool) {
+    w.mu.Lock()
+    once := w.once
+    changed := state.waiting != reason
+    state.waiting = reason
+    if once && indefinite {
+        delete(w.pendingFiles, path)
+    }
+    w.mu.Unlock()
+
+    if once && indefinite {
+        w.logger.Printf("Holding %s for the next run: %s", path, reason)
+        w.record(path, StatusHeld)
+        w.signalChanged()
+        return
     }
+
+    // Log each reason once rather than on every check
+    if changed {
+        w.logger.Printf("Not uploading %s yet: %s", path, reason)
+    }
+    w.scheduleFileCheckAfter(path, wait)
 }
 
 func (w *Watcher) scanExisting() error {
-    w.logger.Printf("Scanning for existing files in %s", w.watchPath)
+    w.mu.Lock()
+    watchPath := w.watchPath
+    w.mu.Unlock()
 
-    entries, err := os.ReadDir(w.watchPath)
+    w.logger.Printf("Scanning for existing files in %s", watchPath)
+
+    entries, err := os.ReadDir(watchPath)
     if err != nil {
         return fmt.Errorf("reading watch directory: %w", err)
     }
@@ -180,22 +610,58 @@ func (w *Watcher) scanExisting() error {
             continue
         }
 
-        path := filepath.Join(w.watchPath, entry.Name())
-        if w.isVideoFile(path) {
+        path := filepath.Join(watchPath, entry.Name())
+        if ok, reason := w.matchName(path); ok {
             w.logger.Printf("Found existing file: %s", path)
             w.scheduleFileCheck(path)
+        } else if reason != "" {
+            w.reject(path, reason)
         }
     }
 
     return nil
 }
 
-func (w *Watcher) isVideoFile(path string) bool {
-    ext := strings.ToLower(filepath.Ext(path))
-    for _, validExt := range w.extensions {
-        if ext == strings.ToLower(validExt) {
-            return true
+// isWatched reports whether path is directly inside the watch folder. Events
+// for a folder that was just replaced may still be in flight.
+func (w *Watcher) isWatched(path string) bool {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    return filepath.Dir(path) == filepath.Clean(w.watchPath)
+}
+
+// temporaryExtensions mark files that are still being downloaded or copied
+// and are renamed to their final name when complete
+var temporaryExtensions = []string{".part", ".crdownload", ".tmp"}
+
+// temporaryFile returns the temporary file next to path that is still
+// being written under another name, if there is one
+func temporaryFile(path string) string {
+    for _, ext := range temporaryExtensions {
+        if _, err := os.Stat(path + ext); err == nil {
+            return path + ext
         }
     }
-    return false
+    return ""
+}
+
+// matchName reports whether path is selected by the filter's name patterns
+// or the video extensions. See Filter.MatchName.
+func (w *Watcher) matchName(path string) (bool, string) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    return w.filter.MatchName(path, w.extensions)
+}
+
+// reject leaves a file alone, logging why only once rather than on every
+// event for it
+func (w *Watcher) reject(path, reason string) {
+    w.mu.Lock()
+    logged := w.rejected[path] == reason
+    w.rejected[path] = reason
+    w.mu.Unlock()
+
+    if !logged {
+        w.logger.Printf("Ignoring %s: %s", path, reason)
+    }
 }
@@ -0,0 +1,318 @@
+package watcher
+
+import (
+    "bytes"
+    "context"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// testSettle keeps the tests fast; files are written in one go
+const testSettle = 100 * time.Millisecond
+
+// recordingHandler accepts every file and remembers which ones it was given
+type recordingHandler struct {
+    mu      sync.Mutex
+    handled []string
+    got     chan string
+}
+
+func newRecordingHandler() *recordingHandler {
+    return &recordingHandler{got: make(chan string, 10)}
+}
+
+func (h *recordingHandler) HandleFile(ctx context.Context, path string) (*Result, error) {
+    h.mu.Lock()
+    h.handled = append(h.handled, path)
+    h.mu.Unlock()
+    h.got <- path
+    return &Result{File: path, Status: StatusUploaded}, nil
+}
+
+func (h *recordingHandler) files() []string {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    return append([]string(nil), h.handled...)
+}
+
+// newTestWatcher watches dir for .mp4 files with a short settle time
+func newTestWatcher(t *testing.T, dir string, handler FileHandler) *Watcher {
+    w, err := New(dir, []string{".mp4"}, 1, Backend{Kind: "fsnotify"}, handler, log.New(io.Discard, "", 0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    w.settleTime = testSettle
+    return w
+}
+
+func writeFile(t *testing.T, path string) {
+    t.Helper()
+    if err := os.WriteFile(path, []byte("video data"), 0644); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func (w *Watcher) isPending(path string) bool {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    _, ok := w.pendingFiles[path]
+    return ok
+}
+
+func TestScheduleFileCheckForgetsMissingFile(t *testing.T) {
+    dir := t.TempDir()
+    w := newTestWatcher(t, dir, newRecordingHandler())
+    defer w.Stop()
+
+    path := filepath.Join(dir, "video.mp4")
+    writeFile(t, path)
+    w.scheduleFileCheckAfter(path, time.Hour)
+    if !w.isPending(path) {
+        t.Fatal("file not queued")
+    }
+
+    // The next check finds the file gone, e.g. while waiting for readiness
+    if err := os.Remove(path); err != nil {
+        t.Fatal(err)
+    }
+    w.scheduleFileCheckAfter(path, time.Hour)
+    if w.isPending(path) {
+        t.Error("missing file still queued")
+    }
+}
+
+func TestRunOnceFinishesWhenWaitingFileDisappears(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "video.mp4")
+    writeFile(t, path)
+
+    w := newTestWatcher(t, dir, newRecordingHandler())
+    w.SetReadiness(Readiness{StableChecks: 100})
+
+    time.AfterFunc(3*testSettle, func() {
+        os.Remove(path)
+    })
+
+    done := make(chan Summary)
+    go func() {
+        summary, _ := w.RunOnce()
+        done <- summary
+    }()
+
+    select {
+    case summary := <-done:
+        if len(summary) != 0 {
+            t.Errorf("summary = %v, want nothing", summary)
+        }
+    case <-time.After(5 * time.Second):
+        w.Stop()
+        t.Fatal("RunOnce did not finish after the file was removed")
+    }
+}
+
+// startWatcher runs w in the background until the test ends
+func startWatcher(t *testing.T, w *Watcher) {
+    go w.Start()
+    t.Cleanup(w.Stop)
+}
+
+// waitHandled waits for the handler to be given path
+func waitHandled(t *testing.T, h *recordingHandler, path string) {
+    t.Helper()
+    deadline := time.After(5 * time.Second)
+    for {
+        select {
+        case got := <-h.got:
+            if got == path {
+                return
+            }
+        case <-deadline:
+            t.Fatalf("%s was not handled; got %v", filepath.Base(path), h.files())
+        }
+    }
+}
+
+func rename(t *testing.T, from, to string) {
+    t.Helper()
+    if err := os.Rename(from, to); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestRenamedToVideoIsUploaded(t *testing.T) {
+    dir := t.TempDir()
+    h := newRecordingHandler()
+    startWatcher(t, newTestWatcher(t, dir, h))
+
+    // Downloaders write to a temporary name and rename it when done
+    temp := filepath.Join(dir, "tmp.part")
+    writeFile(t, temp)
+    path := filepath.Join(dir, "video.mp4")
+    rename(t, temp, path)
+
+    waitHandled(t, h, path)
+    if files := h.files(); len(files) != 1 {
+        t.Errorf("handled %v, want only video.mp4", files)
+    }
+}
+
+func TestMovedInIsUploaded(t *testing.T) {
+    dir := t.TempDir()
+    h := newRecordingHandler()
+    startWatcher(t, newTestWatcher(t, dir, h))
+
+    other := filepath.Join(t.TempDir(), "video.mp4")
+    writeFile(t, other)
+    path := filepath.Join(dir, "video.mp4")
+    rename(t, other, path)
+
+    waitHandled(t, h, path)
+}
+
+func TestRenamedAwayIsForgotten(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "video.mp4")
+    writeFile(t, path)
+
+    h := newRecordingHandler()
+    w := newTestWatcher(t, dir, h)
+    w.settleTime = time.Hour
+    startWatcher(t, w)
+
+    var state *fileState
+    for deadline := time.Now().Add(5 * time.Second); state == nil; time.Sleep(10 * time.Millisecond) {
+        if time.Now().After(deadline) {
+            t.Fatal("file not queued")
+        }
+        w.mu.Lock()
+        state = w.pendingFiles[path]
+        w.mu.Unlock()
+    }
+
+    rename(t, path, filepath.Join(dir, "video.mkv"))
+
+    for deadline := time.Now().Add(5 * time.Second); w.isPending(path); time.Sleep(10 * time.Millisecond) {
+        if time.Now().After(deadline) {
+            t.Fatal("renamed file still queued")
+        }
+    }
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    if state.timer.Stop() {
+        t.Error("check of the renamed file still scheduled")
+    }
+}
+
+func TestTemporaryFilesAreNotQueued(t *testing.T) {
+    dir := t.TempDir()
+    h := newRecordingHandler()
+    w := newTestWatcher(t, dir, h)
+    // Even when the patterns would select them
+    w.SetFilter(Filter{Include: []string{"*"}})
+    startWatcher(t, w)
+
+    var temps []string
+    for _, ext := range temporaryExtensions {
+        temp := filepath.Join(dir, "clip"+ext)
+        writeFile(t, temp)
+        temps = append(temps, temp)
+    }
+
+    // Files are handled in the order they settle, so by the time a later
+    // file is handled the temporary ones would have been too
+    time.Sleep(testSettle)
+    path := filepath.Join(dir, "video.mp4")
+    writeFile(t, path)
+    waitHandled(t, h, path)
+
+    if files := h.files(); len(files) != 1 {
+        t.Errorf("handled %v, want only video.mp4", files)
+    }
+    for _, temp := range temps {
+        if w.isPending(temp) {
+            t.Errorf("%s queued", filepath.Base(temp))
+        }
+    }
+}
+
+func TestVideoHeldWhilePartFileExists(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "video.mp4")
+    part := path + ".part"
+    writeFile(t, path)
+    writeFile(t, part)
+
+    h := newRecordingHandler()
+    w := newTestWatcher(t, dir, h)
+    startWatcher(t, w)
+
+    // Several settle periods pass without the placeholder being uploaded
+    time.Sleep(5 * testSettle)
+    if files := h.files(); len(files) != 0 {
+        t.Fatalf("handled %v while video.mp4.part exists", files)
+    }
+    if !w.isPending(path) {
+        t.Fatal("video.mp4 not waiting for video.mp4.part")
+    }
+
+    // The finished download replaces the placeholder
+    rename(t, part, path)
+    waitHandled(t, h, path)
+}
+
+// holdingHandler holds every file with the same reason
+type holdingHandler struct {
+    calls chan string
+}
+
+func (h *holdingHandler) HandleFile(ctx context.Context, path string) (*Result, error) {
+    h.calls <- path
+    return nil, &DeferError{Reason: "uploads paused", RetryAfter: testSettle}
+}
+
+// logBuffer collects log output written from timer goroutines
+type logBuffer struct {
+    mu  sync.Mutex
+    buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.String()
+}
+
+func TestHoldIsLoggedOnce(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "video.mp4")
+    writeFile(t, path)
+
+    h := &holdingHandler{calls: make(chan string, 10)}
+    w := newTestWatcher(t, dir, h)
+    var logs logBuffer
+    w.logger = log.New(&logs, "", 0)
+    startWatcher(t, w)
+
+    for i := 0; i < 3; i++ {
+        select {
+        case <-h.calls:
+        case <-time.After(5 * time.Second):
+            t.Fatalf("file tried %d times, want 3", i)
+        }
+    }
+    if n := strings.Count(logs.String(), "Holding "+path); n != 1 {
+        t.Errorf("hold logged %d times, want once:\n%s", n, logs.String())
+    }
+}